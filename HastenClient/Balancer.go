@@ -3,7 +3,13 @@ package HastenClient
 import "sync"
 
 type Balancer interface {
-	GetNextIp() string
+	GetNextIp() string // "" while there is no address
+}
+
+// UpdatableBalancer is a Balancer whose addresses a Resolver keeps up to date
+type UpdatableBalancer interface {
+	Balancer
+	Update(ipList []string)
 }
 
 type Strategy int
@@ -22,6 +28,8 @@ func BalancerFactory(strategy Strategy, ipList []string) Balancer {
 
 }
 
+var _ UpdatableBalancer = (*RoundBalancer)(nil)
+
 type RoundBalancer struct {
 	IpList []string
 	Index  int
@@ -39,7 +47,20 @@ func NewBalancer(ipList []string) *RoundBalancer {
 func (b *RoundBalancer) GetNextIp() string {
 	b.lock.Lock()
 	defer b.lock.Unlock()
+	if len(b.IpList) == 0 {
+		return ""
+	}
 	ip := b.IpList[b.Index]
 	b.Index = (b.Index + 1) % len(b.IpList)
 	return ip
 }
+
+// Update replaces the addresses, e.g. when a Resolver learned about new or dead instances
+func (b *RoundBalancer) Update(ipList []string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.IpList = ipList
+	if len(ipList) == 0 || b.Index >= len(ipList) {
+		b.Index = 0
+	}
+}
@@ -0,0 +1,47 @@
+package HastenClient
+
+import (
+	"log"
+	"oh_my_rpc_v2/HastenProtocol"
+)
+
+// Call is one invocation in flight, it is sent on Done once Reply or Error is set
+type Call struct {
+	StructMethod string // e.g. "ComputeS1.Add"
+	Args         any
+	Reply        any // a pointer the response body is decoded into
+	Error        error
+	Done         chan *Call
+
+	Metadata         HastenProtocol.Metadata // sent with the request, see WithMetadata
+	ResponseMetadata HastenProtocol.Metadata // received with the response, also when it is an error
+
+	seq              uint64
+	response         HastenProtocol.Header    // the header of the response, zero until it arrived
+	finished         chan struct{}            // closed by done, Done itself belongs to the caller
+	responseMetadata *HastenProtocol.Metadata // see WithResponseMetadata
+}
+
+func newCall(structMethod string, args any, reply any, done chan *Call) *Call {
+	return &Call{
+		StructMethod: structMethod,
+		Args:         args,
+		Reply:        reply,
+		Done:         done,
+		finished:     make(chan struct{}),
+	}
+}
+
+// done is called exactly once per call, by whoever removed it from the pending calls
+func (call *Call) done() {
+	if call.responseMetadata != nil {
+		*call.responseMetadata = call.ResponseMetadata
+	}
+	close(call.finished)
+	select {
+	case call.Done <- call:
+	default:
+		// the Done channel is full, it's the caller's job to give it enough room
+		log.Println("rpc RpcClient: discarding Call reply due to insufficient Done chan capacity")
+	}
+}
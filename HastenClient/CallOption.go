@@ -0,0 +1,25 @@
+package HastenClient
+
+import "oh_my_rpc_v2/HastenProtocol"
+
+// CallOption configures a single Call or Go
+type CallOption func(call *Call)
+
+// WithMetadata sends md in the request header, handlers read it with HastenServer.MetadataFromContext
+func WithMetadata(md HastenProtocol.Metadata) CallOption {
+	return func(call *Call) {
+		if call.Metadata == nil {
+			call.Metadata = make(HastenProtocol.Metadata, len(md))
+		}
+		for key, value := range md {
+			call.Metadata[key] = value
+		}
+	}
+}
+
+// WithResponseMetadata stores the metadata of the response in *md once the call has completed
+func WithResponseMetadata(md *HastenProtocol.Metadata) CallOption {
+	return func(call *Call) {
+		call.responseMetadata = md
+	}
+}
@@ -1,114 +1,345 @@
 package HastenClient
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"oh_my_rpc_v2/HastenProtocol"
 	"oh_my_rpc_v2/HastenRegistry"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Client struct {
-	codec       HastenProtocol.RpcCodec
-	seq         uint64
-	sendingLock sync.Mutex
-	mutex       sync.Mutex
-	chanMap     map[uint64]*chan *HastenProtocol.RpcProtocol // seq -> *RPCall
+	codec        HastenProtocol.RpcCodec
+	ack          HastenProtocol.OptionAck // the negotiated handshake
+	seq          uint64
+	sendingLock  sync.Mutex
+	mutex        sync.Mutex
+	pending      map[uint64]*Call         // seq -> *Call
+	interceptors []UnaryClientInterceptor // see WithUnaryInterceptors
+	goAway       atomic.Bool              // the server announced its shutdown
+	closing      bool                     // Close has been called
+	shutdown     error                    // why the connection is gone, nil while it works
 }
 
+var (
+	ErrShutdown = errors.New("rpc RpcClient: connection is shut down")
+	ErrGoAway   = errors.New("rpc RpcClient: server is going away")
+)
+
 var _ io.Closer = (*Client)(nil)
 
+/*
+Close
+closes the connection, every call still waiting for its response fails with
+ErrShutdown, and later calls fail at once.
+*/
 func (c *Client) Close() error {
-	//TODO implement me
-	panic("implement me")
+	c.mutex.Lock()
+	if c.closing {
+		c.mutex.Unlock()
+		return ErrShutdown
+	}
+	c.closing = true
+	c.mutex.Unlock()
+
+	// handleResponse notices the closed codec and tears the pending calls down
+	return c.codec.Close()
+}
+
+// IsShutdown reports whether the client can no longer send calls
+func (c *Client) IsShutdown() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closing || c.shutdown != nil
 }
 
+/*
+NewClientWithRegistryCenter
+discovers the instances of serviceName in the registry at registryAddr and
+connects to the one picked by the balancer, on the first endpoint it advertised.
+*/
 func NewClientWithRegistryCenter(
 	registryAddr string, serviceName string,
-	option *HastenProtocol.Option, balancerType Strategy) (*Client, error) {
+	option *HastenProtocol.Option, balancerType Strategy, opts ...ClientOption) (*Client, error) {
 
-	regConn, err := net.Dial("tcp", registryAddr)
+	instances, err := HastenRegistry.NewRegistryClient(registryAddr).Discover(context.Background(), serviceName, nil)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("rpc RpcClient: discover %s: %w", serviceName, err)
 	}
 
-	json.NewEncoder(regConn).Encode(HastenRegistry.RegistryReq{
-		ServiceName: serviceName,
-		OpType:      HastenRegistry.Registry,
-	})
-
-	regResp := HastenRegistry.RegistryResp{}
-	json.NewDecoder(regConn).Decode(&regResp)
+	addrs := make([]string, 0, len(instances))
+	for _, instance := range instances {
+		if len(instance.Endpoints) > 0 {
+			addrs = append(addrs, instance.Endpoints[0].String())
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("rpc RpcClient: discover %s: no endpoint", serviceName)
+	}
 
-	data := regResp.Data.([]string)
 	//balance the ip and create a new client
-	balancer := BalancerFactory(balancerType, data)
-	serviceIp := balancer.GetNextIp()
+	balancer := BalancerFactory(balancerType, addrs)
+	return dialEndpoint(balancer.GetNextIp(), option, opts...)
+}
 
-	conn, err := net.Dial("tcp", serviceIp)
+// dialEndpoint connects to an address handed out by a Balancer, see HastenRegistry.ParseEndpoint
+func dialEndpoint(addr string, option *HastenProtocol.Option, opts ...ClientOption) (*Client, error) {
+	endpoint, err := HastenRegistry.ParseEndpoint(addr)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewClient(conn, option)
+	conn, err := net.Dial(endpoint.Network, endpoint.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewClient(conn, option, opts...)
 }
 
-func NewClient(conn net.Conn, option *HastenProtocol.Option) (*Client, error) {
+// HandshakeTimeout bounds how long NewClient waits for the server's OptionAck
+var HandshakeTimeout = 10 * time.Second
 
-	codec, err := HastenProtocol.CodecFactory(conn, option.CodecType)
+/*
+clampMaxFrameSize
+keeps the limit announced by the server within (0, DefaultMaxFrameSize], a
+server announcing no limit or a larger one must not make the client allocate
+whatever a frame prefix claims.
+*/
+func clampMaxFrameSize(maxFrameSize int) int {
+	if maxFrameSize <= 0 || maxFrameSize > HastenProtocol.DefaultMaxFrameSize {
+		return HastenProtocol.DefaultMaxFrameSize
+	}
+	return maxFrameSize
+}
+
+func NewClient(conn net.Conn, option *HastenProtocol.Option, opts ...ClientOption) (*Client, error) {
+
+	ack, err := handshake(conn, option)
 	if err != nil {
+		_ = conn.Close()
 		return nil, err
 	}
 
-	err = json.NewEncoder(conn).Encode(option)
-	//log.Println("rpc RpcClient: Send option: ", option)
-
+	codec, err := HastenProtocol.CodecFactory(conn, ack.CodecType)
 	if err != nil {
+		_ = conn.Close()
 		return nil, err
 	}
+	if limiter, ok := codec.(HastenProtocol.FrameLimiter); ok {
+		limiter.SetMaxFrameSize(clampMaxFrameSize(ack.Capabilities.MaxFrameSize))
+	}
 
 	client := &Client{
 		codec:       codec,
+		ack:         *ack,
 		seq:         0,
-		chanMap:     make(map[uint64]*chan *HastenProtocol.RpcProtocol),
+		pending:     make(map[uint64]*Call),
 		mutex:       sync.Mutex{},
 		sendingLock: sync.Mutex{},
 	}
+	for _, opt := range opts {
+		opt(client)
+	}
 
 	go client.handleResponse()
 
 	return client, nil
 }
 
-func (c *Client) Call(structMethod string, args any) (*chan *HastenProtocol.RpcProtocol, error) {
+/*
+handshake
+sends the option and waits for the server's ack, nothing else may be written
+on conn before the ack arrives.
+*/
+func handshake(conn net.Conn, option *HastenProtocol.Option) (*HastenProtocol.OptionAck, error) {
+	if !HastenProtocol.IsCodecRegistered(option.CodecType) {
+		return nil, fmt.Errorf("%w: %q", HastenProtocol.ErrUnknownCodec, option.CodecType)
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(HandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	err := HastenProtocol.WriteOption(conn, option)
+	//log.Println("rpc RpcClient: Send option: ", option)
+	if err != nil {
+		return nil, err
+	}
+
+	ack := new(HastenProtocol.OptionAck)
+	err = HastenProtocol.ReadOptionAck(conn, ack)
+	if err != nil {
+		return nil, fmt.Errorf("rpc RpcClient: read option ack: %w", err)
+	}
+
+	if err = HastenProtocol.AckError(ack); err != nil {
+		return nil, err
+	}
+	if ack.CodecType != option.CodecType {
+		return nil, &HastenProtocol.HandshakeError{
+			Code:    HastenProtocol.HandshakeUnknownCodec,
+			Message: "server switched codec to " + string(ack.CodecType),
+		}
+	}
+	return ack, nil
+}
+
+// Capabilities returns what the server announced during the handshake
+func (c *Client) Capabilities() HastenProtocol.Capabilities {
+	return c.ack.Capabilities
+}
 
-	resChan := make(chan *HastenProtocol.RpcProtocol, 1)
-	func() {
-		c.mutex.Lock()
-		defer c.mutex.Unlock()
-		c.seq++
-		c.chanMap[c.seq] = &resChan
-	}()
+// ProtocolVersion returns the protocol version negotiated during the handshake
+func (c *Client) ProtocolVersion() int {
+	return c.ack.Version
+}
+
+/*
+Call
+invokes structMethod and waits for its response, which is decoded into reply.
+An error answered by the server is returned as a *HastenProtocol.Status, a call
+abandoned because of ctx returns the error of ctx.
+*/
+func (c *Client) Call(ctx context.Context, structMethod string, args any, reply any, opts ...CallOption) error {
+	call := <-c.Go(ctx, structMethod, args, reply, make(chan *Call, 1), opts...).Done
+	return call.Error
+}
+
+/*
+Go
+invokes structMethod asynchronously, the returned Call is sent on done once it
+has completed. A nil done gets a new buffered channel, an unbuffered done is
+refused just like in net/rpc.
+
+the deadline of ctx travels to the server, once ctx is done the call fails with
+its error and the server is told to cancel the request.
+*/
+func (c *Client) Go(ctx context.Context, structMethod string, args any, reply any, done chan *Call, opts ...CallOption) *Call {
+	if done == nil {
+		done = make(chan *Call, 1)
+	} else if cap(done) == 0 {
+		log.Panic("rpc RpcClient: done channel is unbuffered")
+	}
+
+	call := newCall(structMethod, args, reply, done)
+	for _, opt := range opts {
+		opt(call)
+	}
+	if len(c.interceptors) > 0 {
+		c.goIntercepted(ctx, call)
+		return call
+	}
+
+	c.send(ctx, call, &HastenProtocol.Header{StructMethod: structMethod, Metadata: call.Metadata})
+	return call
+}
+
+// send writes call with a copy of header, whose seq and deadline are filled in here
+func (c *Client) send(ctx context.Context, call *Call, header *HastenProtocol.Header) {
+	if err := ctx.Err(); err != nil {
+		call.Error = err
+		call.done()
+		return
+	}
+
+	if c.goAway.Load() {
+		call.Error = ErrGoAway
+		call.done()
+		return
+	}
+
+	seq, err := c.registerCall(call)
+	if err != nil {
+		call.Error = err
+		call.done()
+		return
+	}
+
+	requestHeader := *header
+	requestHeader.StructMethod = call.StructMethod
+	requestHeader.Status = nil
+	requestHeader.Seq = seq
+	requestHeader.Flags = 0
 
 	protocol := &HastenProtocol.RpcProtocol{
+		Header: &requestHeader,
+		Body:   call.Args,
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		protocol.Header.Deadline = deadline.UnixNano()
+	}
+
+	err = c.codec.Write(protocol)
+	if err != nil {
+		// the call may already have been failed by terminate
+		if call = c.removeCall(seq); call != nil {
+			call.Error = err
+			call.done()
+		}
+		return
+	}
+
+	if ctx.Done() != nil {
+		go c.watchCall(ctx, call)
+	}
+}
+
+// watchCall abandons the call once ctx is done, unless its response came first
+func (c *Client) watchCall(ctx context.Context, call *Call) {
+	select {
+	case <-call.finished:
+	case <-ctx.Done():
+		if c.removeCall(call.seq) == nil {
+			return
+		}
+		call.Error = ctx.Err()
+		call.done()
+		c.sendCancel(call.seq)
+	}
+}
+
+// sendCancel tells the server that nobody waits for seq anymore, a late response is dropped by handleResponse
+func (c *Client) sendCancel(seq uint64) {
+	err := c.codec.Write(&HastenProtocol.RpcProtocol{
 		Header: &HastenProtocol.Header{
-			StructMethod: structMethod,
-			Error:        "",
-			Seq:          c.seq,
+			Seq:   seq,
+			Flags: HastenProtocol.FlagCancel,
 		},
-		Body: args,
+	})
+	if err != nil {
+		log.Println("rpc RpcClient: send cancel error:", err)
 	}
+}
 
-	err := c.codec.Write(protocol)
-	if err != nil {
-		c.chanMap[c.seq] = nil
-		close(resChan)
-		return nil, err
+func (c *Client) registerCall(call *Call) (uint64, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closing {
+		return 0, ErrShutdown
+	}
+	if c.shutdown != nil {
+		return 0, c.shutdown
 	}
+	c.seq++
+	call.seq = c.seq
+	c.pending[call.seq] = call
+	return call.seq, nil
+}
+
+func (c *Client) removeCall(seq uint64) *Call {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 
-	return &resChan, nil
+	call := c.pending[seq]
+	delete(c.pending, seq)
+	return call
 }
 
 func (c *Client) getSeq() uint64 {
@@ -122,27 +353,66 @@ func (c *Client) getSeq() uint64 {
 
 func (c *Client) handleResponse() {
 
+	var err error
 	for {
 		var h HastenProtocol.Header
-		err := c.codec.ReadHeader(&h)
+		err = c.codec.ReadHeader(&h)
 		if err != nil {
-			return
+			break
 		}
 
-		resChan := c.chanMap[h.Seq]
-		if resChan == nil {
-			var body any
-			err = c.codec.ReadBody(&body)
-			log.Fatal("rpc RpcClient: Invalid seq: ", h.Seq)
-			return
+		// the calls already sent are still answered, only new ones are refused
+		if h.Flags&HastenProtocol.FlagGoAway != 0 {
+			c.goAway.Store(true)
+			_ = c.codec.ReadBody(nil)
+			continue
 		}
 
-		var res any
-		err = c.codec.ReadBody(&res)
-		*resChan <- &HastenProtocol.RpcProtocol{
-			Header: &h,
-			Body:   res,
+		call := c.removeCall(h.Seq)
+		switch {
+		case call == nil:
+			// nobody waits for this seq anymore, e.g. its Write failed or its ctx is done
+			_ = c.codec.ReadBody(nil)
+			log.Println("rpc RpcClient: Invalid seq: ", h.Seq)
+		case h.Status != nil && h.Status.Code != HastenProtocol.CodeOK:
+			call.response = h
+			call.ResponseMetadata = h.Metadata
+			call.Error = h.Status
+			_ = c.codec.ReadBody(nil)
+			call.done()
+		default:
+			call.response = h
+			call.ResponseMetadata = h.Metadata
+			if bodyErr := c.codec.ReadBody(call.Reply); bodyErr != nil {
+				call.Error = fmt.Errorf("rpc RpcClient: reading body: %w", bodyErr)
+			}
+			call.done()
 		}
 	}
 
+	c.terminate(err)
+}
+
+/*
+terminate
+is the only teardown path, both Close and a broken connection end up here. Every
+pending call is answered with ErrShutdown so no caller waits forever.
+*/
+func (c *Client) terminate(cause error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.shutdown = ErrShutdown
+	if !c.closing && cause != nil && cause != io.EOF {
+		c.shutdown = fmt.Errorf("%w: %v", ErrShutdown, cause)
+	}
+	if !c.closing {
+		_ = c.codec.Close()
+	}
+
+	for _, call := range c.pending {
+		call.Error = c.shutdown
+		call.done()
+	}
+	c.pending = make(map[uint64]*Call)
 }
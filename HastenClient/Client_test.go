@@ -1,6 +1,9 @@
 package HastenClient
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"oh_my_rpc_v2/Common"
@@ -20,13 +23,264 @@ func TestClient(t *testing.T) {
 		return
 	}
 
-	resChan, err := client.Call("ComputeS1.Add", &HastenServer.TwoOperands{
+	var res int
+	err = client.Call(context.Background(), "ComputeS1.Add", &HastenServer.TwoOperands{
 		A: 1,
 		B: 2,
-	})
+	}, &res)
 	if err != nil {
 		return
 	}
-	res := <-*resChan
 	log.Println(res)
 }
+
+func TestHandshake(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := HastenServer.NewRpcServer()
+	go server.Accept(listen)
+	t.Cleanup(func() {
+		_ = server.Close()
+		_ = listen.Close()
+	})
+
+	tests := []struct {
+		name   string
+		option HastenProtocol.Option
+		code   HastenProtocol.HandshakeCode
+	}{
+		{"bad magic", HastenProtocol.Option{MagicNumber: 1, CodecType: HastenProtocol.GobType}, HastenProtocol.HandshakeBadMagic},
+		{"unsupported version", HastenProtocol.Option{MagicNumber: HastenProtocol.DefaultMagicNumber, CodecType: HastenProtocol.GobType, Version: -1}, HastenProtocol.HandshakeUnsupportedVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", listen.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = NewClient(conn, &tt.option)
+			if !errors.Is(err, &HastenProtocol.HandshakeError{Code: tt.code}) {
+				t.Fatalf("expected handshake code %v, got %v", tt.code, err)
+			}
+		})
+	}
+
+	conn, err := net.Dial("tcp", listen.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	option := HastenProtocol.Option{MagicNumber: HastenProtocol.DefaultMagicNumber, CodecType: HastenProtocol.JsonType}
+	client, err := NewClient(conn, &option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.ProtocolVersion() != HastenProtocol.MinProtocolVersion {
+		t.Fatalf("unexpected protocol version %d", client.ProtocolVersion())
+	}
+}
+
+func TestClampMaxFrameSize(t *testing.T) {
+	tests := []struct {
+		announced int
+		want      int
+	}{
+		{0, HastenProtocol.DefaultMaxFrameSize},
+		{-1, HastenProtocol.DefaultMaxFrameSize},
+		{1 << 10, 1 << 10},
+		{HastenProtocol.DefaultMaxFrameSize, HastenProtocol.DefaultMaxFrameSize},
+		{HastenProtocol.DefaultMaxFrameSize + 1, HastenProtocol.DefaultMaxFrameSize},
+	}
+	for _, tt := range tests {
+		if got := clampMaxFrameSize(tt.announced); got != tt.want {
+			t.Errorf("clampMaxFrameSize(%d) = %d, want %d", tt.announced, got, tt.want)
+		}
+	}
+}
+
+// startSilentServer accepts the handshake and then never answers, it hands the connection to onConn
+func startSilentServer(t *testing.T, onConn func(conn net.Conn)) string {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listen.Close() })
+
+	go func() {
+		conn, err := listen.Accept()
+		if err != nil {
+			return
+		}
+		var option HastenProtocol.Option
+		if err = HastenProtocol.ReadOption(conn, &option); err != nil {
+			return
+		}
+		_ = HastenProtocol.WriteOptionAck(conn, &HastenProtocol.OptionAck{
+			Accepted:  true,
+			CodecType: option.CodecType,
+			Version:   HastenProtocol.ProtocolVersion,
+		})
+		onConn(conn)
+	}()
+	return listen.Addr().String()
+}
+
+func TestClientClose(t *testing.T) {
+	addr := startSilentServer(t, func(conn net.Conn) {})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(conn, &HastenProtocol.DefaultOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res int
+	call := client.Go(context.Background(), "ComputeS1.Add", &HastenServer.TwoOperands{A: 1, B: 2}, &res, nil)
+	if err = client.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if call = <-call.Done; call.Error != ErrShutdown {
+		t.Fatalf("expected the pending call to fail with ErrShutdown, got %v", call.Error)
+	}
+	if err = client.Call(context.Background(), "ComputeS1.Add", &HastenServer.TwoOperands{}, &res); !errors.Is(err, ErrShutdown) {
+		t.Fatalf("expected ErrShutdown, got %v", err)
+	}
+	if err = client.Close(); !errors.Is(err, ErrShutdown) {
+		t.Fatalf("expected ErrShutdown from a second Close, got %v", err)
+	}
+}
+
+func TestClientBrokenConnection(t *testing.T) {
+	drop := make(chan struct{})
+	addr := startSilentServer(t, func(conn net.Conn) {
+		<-drop
+		_ = conn.Close()
+	})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(conn, &HastenProtocol.DefaultOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res int
+	call := client.Go(context.Background(), "ComputeS1.Add", &HastenServer.TwoOperands{A: 1, B: 2}, &res, nil)
+	close(drop)
+
+	if call = <-call.Done; !errors.Is(call.Error, ErrShutdown) {
+		t.Fatalf("expected the pending call to fail with ErrShutdown, got %v", call.Error)
+	}
+	if err = client.Call(context.Background(), "ComputeS1.Add", &HastenServer.TwoOperands{}, &res); !errors.Is(err, ErrShutdown) {
+		t.Fatalf("expected ErrShutdown, got %v", err)
+	}
+}
+
+func TestCallTypedReply(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := HastenServer.NewRpcServer()
+	_ = server.RegisterService(new(HastenServer.ComputeS1))
+	go server.Accept(listen)
+	defer server.Close()
+
+	for _, codecType := range []HastenProtocol.CodecEnum{HastenProtocol.GobType, HastenProtocol.JsonType} {
+		t.Run(string(codecType), func(t *testing.T) {
+			conn, err := net.Dial("tcp", listen.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			option := HastenProtocol.DefaultOption
+			option.CodecType = codecType
+			client, err := NewClient(conn, &option)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			var sum int
+			err = client.Call(context.Background(), "ComputeS1.Add", &HastenServer.TwoOperands{A: 1, B: 2}, &sum)
+			if err != nil || sum != 3 {
+				t.Fatalf("ComputeS1.Add: %d %v", sum, err)
+			}
+		})
+	}
+}
+
+func TestUnaryClientInterceptors(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := HastenServer.NewRpcServer()
+	_ = server.RegisterService(new(HastenServer.ComputeS1))
+	go server.Accept(listen)
+	defer server.Close()
+
+	var order []string
+	var lastResp HastenProtocol.Header
+	record := func(name string) UnaryClientInterceptor {
+		return func(ctx context.Context, structMethod string, args any, reply any,
+			header *HastenProtocol.Header, resp *HastenProtocol.Header, invoker UnaryInvoker) error {
+			order = append(order, name+">"+structMethod)
+			err := invoker(ctx, structMethod, args, reply, header, resp)
+			order = append(order, "<"+name)
+			lastResp = *resp
+			return err
+		}
+	}
+	retried := 0
+	retryOnce := func(ctx context.Context, structMethod string, args any, reply any,
+		header *HastenProtocol.Header, resp *HastenProtocol.Header, invoker UnaryInvoker) error {
+		if err := invoker(ctx, structMethod, args, reply, header, resp); err != nil {
+			return err
+		}
+		retried++
+		return invoker(ctx, structMethod, args, reply, header, resp)
+	}
+
+	conn, err := net.Dial("tcp", listen.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(conn, &HastenProtocol.DefaultOption,
+		WithUnaryInterceptors(record("outer"), record("inner")), WithUnaryInterceptors(retryOnce))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var sum int
+	err = client.Call(context.Background(), "ComputeS1.Add", &HastenServer.TwoOperands{A: 1, B: 2}, &sum)
+	if err != nil || sum != 3 {
+		t.Fatalf("ComputeS1.Add: %d %v", sum, err)
+	}
+	want := []string{"outer>ComputeS1.Add", "inner>ComputeS1.Add", "<inner", "<outer"}
+	if fmt.Sprint(order) != fmt.Sprint(want) || retried != 1 {
+		t.Fatalf("interceptor order %v retried %d, want %v retried 1", order, retried, want)
+	}
+
+	if lastResp.StructMethod != "ComputeS1.Add" || lastResp.Seq == 0 || lastResp.Status != nil {
+		t.Fatalf("unexpected response header %+v", lastResp)
+	}
+
+	call := <-client.Go(context.Background(), "ComputeS1.Add", &HastenServer.TwoOperands{A: 2, B: 2}, &sum, nil).Done
+	if call.Error != nil || sum != 4 {
+		t.Fatalf("Go through the interceptors: %d %v", sum, call.Error)
+	}
+
+	err = client.Call(context.Background(), "ComputeS1.Missing", &HastenServer.TwoOperands{}, &sum)
+	if !errors.Is(err, &HastenProtocol.Status{Code: HastenProtocol.CodeNotFound}) {
+		t.Fatalf("expected CodeNotFound, got %v", err)
+	}
+	if lastResp.Status == nil || lastResp.Status.Code != HastenProtocol.CodeNotFound {
+		t.Fatalf("the interceptors did not see the status of the response: %+v", lastResp)
+	}
+}
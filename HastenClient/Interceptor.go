@@ -0,0 +1,85 @@
+package HastenClient
+
+import (
+	"context"
+	"oh_my_rpc_v2/HastenProtocol"
+)
+
+// ClientOption configures a Client in NewClient
+type ClientOption func(client *Client)
+
+/*
+UnaryInvoker
+sends the call and waits for its response, it is the end of the interceptor
+chain. Once it returns, resp holds the response header: its seq, status and
+metadata. resp stays zero when no response arrived, e.g. the ctx was done first.
+*/
+type UnaryInvoker func(
+	ctx context.Context, structMethod string, args any, reply any,
+	header *HastenProtocol.Header, resp *HastenProtocol.Header) error
+
+/*
+UnaryClientInterceptor
+wraps every invocation made with Call or Go. header is the request header
+before the seq is assigned, changes to it are sent to the server, e.g. adding
+to header.Metadata. resp is filled in by invoker with the response header.
+invoker may be called any number of times, e.g. to retry, each time with a new
+seq.
+*/
+type UnaryClientInterceptor func(
+	ctx context.Context, structMethod string, args any, reply any,
+	header *HastenProtocol.Header, resp *HastenProtocol.Header, invoker UnaryInvoker) error
+
+/*
+WithUnaryInterceptors
+appends interceptors to the chain of the client. The first interceptor is the
+outermost one: it runs first before the call is sent and last after it returned.
+*/
+func WithUnaryInterceptors(interceptors ...UnaryClientInterceptor) ClientOption {
+	return func(client *Client) {
+		client.interceptors = append(client.interceptors, interceptors...)
+	}
+}
+
+func chainUnaryInterceptors(interceptors []UnaryClientInterceptor, final UnaryInvoker) UnaryInvoker {
+	invoker := final
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, structMethod string, args any, reply any,
+			header *HastenProtocol.Header, resp *HastenProtocol.Header) error {
+			return interceptor(ctx, structMethod, args, reply, header, resp, next)
+		}
+	}
+	return invoker
+}
+
+// invoker is the final UnaryInvoker of call: one call on the wire, waited for
+func (c *Client) invoker(call *Call) UnaryInvoker {
+	return func(ctx context.Context, structMethod string, args any, reply any,
+		header *HastenProtocol.Header, resp *HastenProtocol.Header) error {
+		wire := newCall(structMethod, args, reply, make(chan *Call, 1))
+		c.send(ctx, wire, header)
+		<-wire.finished
+		call.ResponseMetadata = wire.ResponseMetadata
+		if resp != nil {
+			*resp = wire.response
+		}
+		return wire.Error
+	}
+}
+
+// goIntercepted runs the interceptor chain for call in the background and completes call with its result
+func (c *Client) goIntercepted(ctx context.Context, call *Call) {
+	// interceptors may add to the metadata without touching call.Metadata
+	metadata := call.Metadata.Clone()
+	if metadata == nil {
+		metadata = make(HastenProtocol.Metadata)
+	}
+	header := &HastenProtocol.Header{StructMethod: call.StructMethod, Metadata: metadata}
+	invoker := chainUnaryInterceptors(c.interceptors, c.invoker(call))
+
+	go func() {
+		call.Error = invoker(ctx, call.StructMethod, call.Args, call.Reply, header, &HastenProtocol.Header{})
+		call.done()
+	}()
+}
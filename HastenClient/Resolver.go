@@ -0,0 +1,193 @@
+package HastenClient
+
+import (
+	"context"
+	"errors"
+	"log"
+	"oh_my_rpc_v2/HastenProtocol"
+	"oh_my_rpc_v2/HastenRegistry"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ResolverRetryInterval is how long a Resolver waits before it watches again after its watch broke
+var ResolverRetryInterval = time.Second
+
+var ErrNoInstance = errors.New("rpc RpcClient: no instance to dial")
+
+/*
+Resolver
+keeps the instances of a service up to date through a watch on the registry and
+feeds the first endpoint of every instance to its UpdatableBalancer. A broken watch is
+started again, its snapshot replaces whatever the Resolver knew.
+*/
+type Resolver struct {
+	registry    *HastenRegistry.RegistryClient
+	serviceName string
+	filter      *HastenRegistry.Filter
+	balancer    Balancer
+
+	lock      sync.Mutex
+	instances map[string]HastenRegistry.Instance // ID -> instance
+	revision  uint64
+	watcher   *HastenRegistry.Watcher
+	closed    bool
+	done      chan struct{}
+}
+
+/*
+NewResolver
+watches the instances of serviceName which match filter, a nil filter matches
+all of them. It fails if the first watch cannot be set up, an empty service is
+fine. Only an UpdatableBalancer learns about the instances, any other balancer
+keeps the addresses it was built with.
+*/
+func NewResolver(
+	ctx context.Context, registryAddr string, serviceName string,
+	filter *HastenRegistry.Filter, balancer Balancer) (*Resolver, error) {
+
+	r := &Resolver{
+		registry:    HastenRegistry.NewRegistryClient(registryAddr),
+		serviceName: serviceName,
+		filter:      filter,
+		balancer:    balancer,
+		done:        make(chan struct{}),
+	}
+
+	watcher, err := r.registry.Watch(ctx, serviceName, filter)
+	if err != nil {
+		return nil, err
+	}
+	r.reset(watcher)
+	go r.run(watcher)
+	return r, nil
+}
+
+// Instances returns the instances known right now, sorted by ID
+func (r *Resolver) Instances() []HastenRegistry.Instance {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.sortedInstances()
+}
+
+// Revision is the registry revision the instances are up to date with
+func (r *Resolver) Revision() uint64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.revision
+}
+
+// Dial connects to the instance picked by the balancer
+func (r *Resolver) Dial(option *HastenProtocol.Option, opts ...ClientOption) (*Client, error) {
+	addr := r.balancer.GetNextIp()
+	if addr == "" {
+		return nil, ErrNoInstance
+	}
+	return dialEndpoint(addr, option, opts...)
+}
+
+// Close stops watching, the balancer keeps the last addresses
+func (r *Resolver) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	close(r.done)
+	return r.watcher.Close()
+}
+
+func (r *Resolver) run(watcher *HastenRegistry.Watcher) {
+	for {
+		for {
+			event, err := watcher.Next()
+			if err != nil {
+				break
+			}
+			r.apply(event)
+		}
+		_ = watcher.Close()
+
+		var err error
+		for watcher = nil; watcher == nil; {
+			select {
+			case <-r.done:
+				return
+			case <-time.After(ResolverRetryInterval):
+			}
+
+			watcher, err = r.registry.Watch(context.Background(), r.serviceName, r.filter)
+			if err != nil {
+				log.Println("rpc RpcClient: resolver watch error:", err)
+			}
+		}
+
+		if !r.reset(watcher) {
+			_ = watcher.Close()
+			return
+		}
+	}
+}
+
+// reset replaces the instances with the snapshot of watcher, false if the resolver has been closed
+func (r *Resolver) reset(watcher *HastenRegistry.Watcher) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.closed {
+		return false
+	}
+	r.watcher = watcher
+	r.revision = watcher.Revision
+	r.instances = make(map[string]HastenRegistry.Instance, len(watcher.Instances))
+	for _, instance := range watcher.Instances {
+		r.instances[instance.ID] = instance
+	}
+	r.updateBalancer()
+	return true
+}
+
+func (r *Resolver) apply(event HastenRegistry.WatchEvent) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if event.Revision <= r.revision {
+		return
+	}
+	r.revision = event.Revision
+
+	switch event.Type {
+	case HastenRegistry.EventAdd, HastenRegistry.EventUpdate:
+		r.instances[event.Instance.ID] = event.Instance
+	case HastenRegistry.EventRemove:
+		delete(r.instances, event.Instance.ID)
+	}
+	r.updateBalancer()
+}
+
+func (r *Resolver) updateBalancer() {
+	instances := r.sortedInstances()
+	addrs := make([]string, 0, len(instances))
+	for _, instance := range instances {
+		if len(instance.Endpoints) > 0 {
+			addrs = append(addrs, instance.Endpoints[0].String())
+		}
+	}
+	if updatable, ok := r.balancer.(UpdatableBalancer); ok {
+		updatable.Update(addrs)
+	}
+}
+
+func (r *Resolver) sortedInstances() []HastenRegistry.Instance {
+	instances := make([]HastenRegistry.Instance, 0, len(r.instances))
+	for _, instance := range r.instances {
+		instances = append(instances, instance)
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].ID < instances[j].ID
+	})
+	return instances
+}
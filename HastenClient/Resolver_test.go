@@ -0,0 +1,66 @@
+package HastenClient
+
+import (
+	"context"
+	"net"
+	"oh_my_rpc_v2/HastenRegistry"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond, the resolver learns about changes asynchronously
+func waitFor(t *testing.T, what string, cond func() bool) {
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestResolver(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	registryServer := HastenRegistry.StartRegistryServer()
+	t.Cleanup(func() {
+		_ = listen.Close()
+		_ = registryServer.Close()
+	})
+	go registryServer.Serve(listen)
+	registry := HastenRegistry.NewRegistryClient(listen.Addr().String())
+
+	balancer := NewBalancer(nil)
+	resolver, err := NewResolver(context.Background(), listen.Addr().String(), "ComputeS1", nil, balancer)
+	if err != nil {
+		t.Fatalf("NewResolver: %v", err)
+	}
+	defer resolver.Close()
+	if balancer.GetNextIp() != "" {
+		t.Fatal("expected no address before any instance registered")
+	}
+
+	endpoint := HastenRegistry.Endpoint{Network: "tcp", Address: "10.0.0.7:12312"}
+	reg, err := registry.Register(context.Background(), "ComputeS1",
+		HastenRegistry.Instance{ID: "compute-1", Endpoints: []HastenRegistry.Endpoint{endpoint}})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	waitFor(t, "the instance to appear", func() bool { return len(resolver.Instances()) == 1 })
+	if addr := balancer.GetNextIp(); addr != endpoint.String() {
+		t.Fatalf("expected %s from the balancer, got %q", endpoint, addr)
+	}
+
+	if err = reg.Deregister(context.Background()); err != nil {
+		t.Fatalf("deregister: %v", err)
+	}
+	waitFor(t, "the instance to disappear", func() bool { return len(resolver.Instances()) == 0 })
+	if _, err = resolver.Dial(nil); err != ErrNoInstance {
+		t.Fatalf("expected ErrNoInstance, got %v", err)
+	}
+	if resolver.Revision() < 2 {
+		t.Fatalf("expected the revision to follow both changes, got %d", resolver.Revision())
+	}
+}
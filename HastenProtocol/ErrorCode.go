@@ -0,0 +1,47 @@
+package HastenProtocol
+
+import "fmt"
+
+// ErrorCode classifies the Status of a response, the values are part of the wire format
+type ErrorCode int
+
+const (
+	CodeOK                 ErrorCode = iota // no error
+	CodeUnknown                             // the handler returned an error without a code
+	CodeInternal                            // the server broke while handling the request, e.g. the handler panicked
+	CodeCanceled                            // the caller canceled the request
+	CodeInvalidArgument                     // the request is malformed, e.g. the args cannot be decoded
+	CodeDeadlineExceeded                    // the deadline of the caller passed
+	CodeNotFound                            // the service or the method does not exist
+	CodeAlreadyExists                       // the entity the request would create exists already
+	CodePermissionDenied                    // the caller is not allowed to call the method
+	CodeResourceExhausted                   // a quota or a size limit was hit
+	CodeFailedPrecondition                  // the system is not in the state the request requires
+	CodeUnimplemented                       // the method exists but is not supported
+	CodeUnavailable                         // the server cannot take the request right now, e.g. it is shutting down
+	CodeUnauthenticated                     // the caller could not be identified
+)
+
+var codeNames = map[ErrorCode]string{
+	CodeOK:                 "ok",
+	CodeUnknown:            "unknown",
+	CodeInternal:           "internal",
+	CodeCanceled:           "canceled",
+	CodeInvalidArgument:    "invalid argument",
+	CodeDeadlineExceeded:   "deadline exceeded",
+	CodeNotFound:           "not found",
+	CodeAlreadyExists:      "already exists",
+	CodePermissionDenied:   "permission denied",
+	CodeResourceExhausted:  "resource exhausted",
+	CodeFailedPrecondition: "failed precondition",
+	CodeUnimplemented:      "unimplemented",
+	CodeUnavailable:        "unavailable",
+	CodeUnauthenticated:    "unauthenticated",
+}
+
+func (c ErrorCode) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("code %d", int(c))
+}
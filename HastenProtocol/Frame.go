@@ -0,0 +1,154 @@
+package HastenProtocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+Frame
+every message on a connection, including the Option handshake, travels in a frame:
+
+	| magic uint16 | version uint8 | flags uint8 | header length uint32 | body length uint32 | header | body |
+
+all integers are big endian. The lengths make it possible to size, validate and
+skip a frame without understanding the codec that produced its header and body.
+*/
+type Frame struct {
+	Flags  FrameFlag
+	Header []byte
+	Body   []byte
+}
+
+type FrameFlag uint8
+
+const (
+	FlagHandshake FrameFlag = 1 << iota // the frame carries an Option or an OptionAck
+	FlagGoAway                          // the server is shutting down and takes no new requests on this connection
+	FlagCancel                          // the caller abandoned the request with the same seq
+)
+
+const (
+	FrameMagic      uint16 = 0x4873 // "Hs"
+	FrameVersion    uint8  = 1
+	FramePrefixSize        = 12 // magic + version + flags + header length + body length
+
+	DefaultMaxFrameSize = 16 << 20 // header + body
+)
+
+var (
+	// ErrBadFrameMagic and ErrBadFrameVersion leave the stream in an unknown position, the connection must be dropped
+	ErrBadFrameMagic   = errors.New("rpc: bad frame magic number")
+	ErrBadFrameVersion = errors.New("rpc: unsupported frame version")
+	// ErrFrameTooLarge is recoverable on read: the oversized frame has been skipped and the stream is still aligned
+	ErrFrameTooLarge = errors.New("rpc: frame too large")
+)
+
+type frameSizeError struct {
+	size, limit int64
+}
+
+func (e *frameSizeError) Error() string {
+	return fmt.Sprintf("%v: %d bytes, limit %d", ErrFrameTooLarge, e.size, e.limit)
+}
+
+func (e *frameSizeError) Unwrap() []error {
+	return []error{ErrFrameTooLarge, NewStatus(CodeResourceExhausted, e.Error())}
+}
+
+// Size is the number of header and body bytes, the prefix is not counted
+func (f *Frame) Size() int {
+	return len(f.Header) + len(f.Body)
+}
+
+/*
+WriteFrame
+writes the whole frame with a single call to w, so a buffered writer never
+holds half a frame.
+*/
+func WriteFrame(w io.Writer, frame *Frame, maxFrameSize int) error {
+	if maxFrameSize > 0 && frame.Size() > maxFrameSize {
+		return &frameSizeError{size: int64(frame.Size()), limit: int64(maxFrameSize)}
+	}
+
+	data := make([]byte, FramePrefixSize, FramePrefixSize+frame.Size())
+	binary.BigEndian.PutUint16(data[0:2], FrameMagic)
+	data[2] = FrameVersion
+	data[3] = byte(frame.Flags)
+	binary.BigEndian.PutUint32(data[4:8], uint32(len(frame.Header)))
+	binary.BigEndian.PutUint32(data[8:12], uint32(len(frame.Body)))
+	data = append(data, frame.Header...)
+	data = append(data, frame.Body...)
+
+	_, err := w.Write(data)
+	return err
+}
+
+/*
+ReadFrame
+reads exactly one frame from r and never reads past it, which lets the handshake
+run on the raw connection before a codec wraps it.
+
+an oversized frame is skipped with ErrFrameTooLarge. Its header is still read
+and returned without the body when the header alone fits the limit, so the
+sender can be told which of its frames was refused.
+*/
+func ReadFrame(r io.Reader, maxFrameSize int) (*Frame, error) {
+	var prefix [FramePrefixSize]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+
+	if magic := binary.BigEndian.Uint16(prefix[0:2]); magic != FrameMagic {
+		return nil, fmt.Errorf("%w: %#x", ErrBadFrameMagic, magic)
+	}
+	if version := prefix[2]; version != FrameVersion {
+		return nil, fmt.Errorf("%w: %d", ErrBadFrameVersion, version)
+	}
+
+	headerLen := int64(binary.BigEndian.Uint32(prefix[4:8]))
+	bodyLen := int64(binary.BigEndian.Uint32(prefix[8:12]))
+
+	if maxFrameSize > 0 && headerLen+bodyLen > int64(maxFrameSize) {
+		tooLarge := &frameSizeError{size: headerLen + bodyLen, limit: int64(maxFrameSize)}
+		if headerLen > int64(maxFrameSize) {
+			// skip the payload so the next frame can still be read
+			if _, err := io.CopyN(io.Discard, r, headerLen+bodyLen); err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			return nil, tooLarge
+		}
+
+		frame := &Frame{Flags: FrameFlag(prefix[3]), Header: make([]byte, headerLen)}
+		if _, err := io.ReadFull(r, frame.Header); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if _, err := io.CopyN(io.Discard, r, bodyLen); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return frame, tooLarge
+	}
+
+	frame := &Frame{
+		Flags:  FrameFlag(prefix[3]),
+		Header: make([]byte, headerLen),
+		Body:   make([]byte, bodyLen),
+	}
+	if _, err := io.ReadFull(r, frame.Header); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if _, err := io.ReadFull(r, frame.Body); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return frame, nil
+}
+
+// unexpectedEOF reports a stream ending inside a frame as io.ErrUnexpectedEOF
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
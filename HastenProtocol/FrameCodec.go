@@ -0,0 +1,210 @@
+package HastenProtocol
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// Serializer turns a single header or body into bytes, each call must be self-contained
+type Serializer interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// FrameLimiter is implemented by codecs which enforce a frame size limit
+type FrameLimiter interface {
+	SetMaxFrameSize(maxFrameSize int)
+}
+
+var (
+	ErrMalformedFrame = errors.New("rpc: malformed frame")
+	// ErrUnencodable is returned by Write for a header or body the serializer refuses, nothing has been written
+	ErrUnencodable = errors.New("rpc: cannot encode frame")
+)
+
+/*
+frameCodec
+puts the header and the body of one RpcProtocol into one Frame, the concrete
+codecs only decide how the header and the body are serialized.
+*/
+type frameCodec struct {
+	name         string
+	conn         io.ReadWriteCloser
+	reader       *bufio.Reader
+	buf          *bufio.Writer // the buf is derived from the conn
+	serializer   Serializer
+	maxFrameSize atomic.Int64 // read by the reader and the writers alike
+	body         []byte       // the body of the frame whose header was read last
+	writeLock    sync.Locker
+}
+
+var _ FrameLimiter = (*frameCodec)(nil)
+
+func newFrameCodec(name string, conn io.ReadWriteCloser, serializer Serializer) *frameCodec {
+	c := &frameCodec{
+		name:       name,
+		conn:       conn,
+		reader:     bufio.NewReader(conn),
+		buf:        bufio.NewWriter(conn),
+		serializer: serializer,
+		writeLock:  &sync.Mutex{},
+	}
+	c.maxFrameSize.Store(DefaultMaxFrameSize)
+	return c
+}
+
+// SetMaxFrameSize limits both the frames read and written, 0 disables the limit
+func (c *frameCodec) SetMaxFrameSize(maxFrameSize int) {
+	c.maxFrameSize.Store(int64(maxFrameSize))
+}
+
+func (c *frameCodec) Close() error {
+	err := c.conn.Close()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *frameCodec) ReadServiceName(serviceName *string) error {
+	frame, err := c.readFrame()
+	if err != nil {
+		return err
+	}
+	return c.serializer.Unmarshal(frame.Header, serviceName)
+}
+
+func (c *frameCodec) WriteServiceName(serviceName string) error {
+	data, err := c.serializer.Marshal(serviceName)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(&Frame{Header: data})
+}
+
+/*
+ReadHeader
+reads a whole frame, the body is kept until the following ReadBody. A frame
+whose header cannot be decoded has been consumed completely, so the next
+ReadHeader starts on a frame boundary.
+
+a header with too much metadata is still decoded, so its seq can be answered,
+but ReadHeader reports ErrMetadataTooLarge. The same goes for the header of an
+oversized frame and ErrFrameTooLarge, its body is gone. An oversized header
+cannot be decoded at all and reports ErrMalformedFrame as well.
+*/
+func (c *frameCodec) ReadHeader(header *Header) error {
+	c.body = nil
+
+	frame, err := c.readFrame()
+	if frame == nil {
+		if errors.Is(err, ErrFrameTooLarge) {
+			return fmt.Errorf("%w: header: %w", ErrMalformedFrame, err)
+		}
+		return err
+	}
+	tooLarge := err
+
+	if err = c.serializer.Unmarshal(frame.Header, header); err != nil {
+		return fmt.Errorf("%w: header: %v", ErrMalformedFrame, err)
+	}
+	header.Flags = frame.Flags
+	c.body = frame.Body
+	if tooLarge != nil {
+		return tooLarge
+	}
+	return checkMetadata(header.Metadata)
+}
+
+/*
+ReadBody
+in Server side: the body is called reply
+in client side: the body is called args
+
+a nil body discards the body of the current frame.
+*/
+func (c *frameCodec) ReadBody(body any) error {
+	data := c.body
+	c.body = nil
+
+	if body == nil || len(data) == 0 {
+		return nil
+	}
+
+	if err := c.serializer.Unmarshal(data, body); err != nil {
+		return fmt.Errorf("%w: body: %v", ErrMalformedFrame, err)
+	}
+	return nil
+}
+
+/*
+Write
+serializes the header and the body before anything touches the connection, so
+an encoding error, too much metadata or an oversized frame leaves the stream
+intact. Only a failed write to the connection closes it.
+*/
+func (c *frameCodec) Write(rpcProtocol *RpcProtocol) error {
+	h := rpcProtocol.Header
+	body := rpcProtocol.Body
+
+	if err := checkMetadata(h.Metadata); err != nil {
+		return err
+	}
+
+	// the flags travel in the frame only, whatever the serializer makes of the field
+	serialized := *h
+	serialized.Flags = 0
+	headerData, err := c.serializer.Marshal(&serialized)
+	if err != nil {
+		log.Printf("rpc: %s error encoding header: %v\n", c.name, err)
+		return fmt.Errorf("%w: header: %v", ErrUnencodable, err)
+	}
+
+	var bodyData []byte
+	if body != nil {
+		if bodyData, err = c.serializer.Marshal(body); err != nil {
+			log.Printf("rpc: %s error encoding body: %v\n", c.name, err)
+			return fmt.Errorf("%w: body: %v", ErrUnencodable, err)
+		}
+	}
+
+	flags := h.Flags &^ FlagHandshake
+	if err = c.writeFrame(&Frame{Flags: flags, Header: headerData, Body: bodyData}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *frameCodec) readFrame() (*Frame, error) {
+	// an oversized frame comes with its header and ErrFrameTooLarge
+	frame, err := ReadFrame(c.reader, int(c.maxFrameSize.Load()))
+	if frame == nil {
+		return nil, err
+	}
+	if frame.Flags&FlagHandshake != 0 {
+		return nil, fmt.Errorf("%w: unexpected handshake frame", ErrMalformedFrame)
+	}
+	return frame, err
+}
+
+func (c *frameCodec) writeFrame(frame *Frame) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	err := WriteFrame(c.buf, frame, int(c.maxFrameSize.Load()))
+	if errors.Is(err, ErrFrameTooLarge) {
+		return err
+	}
+	if err == nil {
+		err = c.buf.Flush()
+	}
+	if err != nil {
+		_ = c.Close()
+	}
+	return err
+}
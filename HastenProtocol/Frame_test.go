@@ -0,0 +1,68 @@
+package HastenProtocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestReadFrame(t *testing.T) {
+	var stream bytes.Buffer
+	frames := []*Frame{
+		{Header: []byte("h1"), Body: []byte("b1")},
+		{Header: []byte("oversized"), Body: bytes.Repeat([]byte{1}, 64)},
+		{Flags: FlagHandshake, Header: []byte("h3")},
+	}
+	for _, frame := range frames {
+		if err := WriteFrame(&stream, frame, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	frame, err := ReadFrame(&stream, 32)
+	if err != nil || string(frame.Header) != "h1" || string(frame.Body) != "b1" {
+		t.Fatalf("read first frame: %v %+v", err, frame)
+	}
+
+	// the oversized frame is skipped without losing the frame behind it, its header is kept
+	frame, err = ReadFrame(&stream, 32)
+	if !errors.Is(err, ErrFrameTooLarge) || frame == nil || string(frame.Header) != "oversized" || frame.Body != nil {
+		t.Fatalf("expected ErrFrameTooLarge with the header, got %v %+v", err, frame)
+	}
+	if code := CodeOf(err); code != CodeResourceExhausted {
+		t.Fatalf("expected CodeResourceExhausted, got %v", code)
+	}
+
+	frame, err = ReadFrame(&stream, 32)
+	if err != nil || string(frame.Header) != "h3" || frame.Flags != FlagHandshake {
+		t.Fatalf("read third frame: %v %+v", err, frame)
+	}
+
+	if _, err = ReadFrame(&stream, 32); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestReadFrameRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"bad magic", []byte(`{"MagicNumber":3927900}`), ErrBadFrameMagic},
+		{"bad version", []byte{0x48, 0x73, 9, 0, 0, 0, 0, 0, 0, 0, 0, 0}, ErrBadFrameVersion},
+		{"truncated", []byte{0x48, 0x73, 1, 0, 0, 0, 0, 4, 0, 0, 0, 0, 'h'}, io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadFrame(bytes.NewReader(tt.data), 0); !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+
+	if err := WriteFrame(io.Discard, &Frame{Body: make([]byte, 8)}, 4); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("expected ErrFrameTooLarge on write, got %v", err)
+	}
+}
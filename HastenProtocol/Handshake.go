@@ -0,0 +1,158 @@
+package HastenProtocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+the handshake:
+ 1. client -> server: Option
+ 2. server -> client: OptionAck
+ 3. both sides switch to the codec named in OptionAck.CodecType
+
+the Option and the OptionAck are json documents in the header of a frame flagged
+FlagHandshake. The client must not write any rpc traffic before it has read the
+OptionAck.
+*/
+
+const (
+	ProtocolVersion    = 1 // the newest version this package speaks
+	MinProtocolVersion = 1 // the oldest version this package still accepts
+)
+
+// maxHandshakeFrameSize bounds the frames read before the codec limits are known
+const maxHandshakeFrameSize = 64 << 10
+
+// Capabilities are the optional features the server offers on an accepted connection
+type Capabilities struct {
+	Compression  bool
+	Streaming    bool
+	MaxFrameSize int // 0 means no limit is announced
+}
+
+type HandshakeCode int
+
+const (
+	HandshakeOK HandshakeCode = iota
+	HandshakeBadMagic
+	HandshakeUnknownCodec
+	HandshakeUnsupportedVersion
+	HandshakeMalformed
+)
+
+func (c HandshakeCode) String() string {
+	switch c {
+	case HandshakeOK:
+		return "ok"
+	case HandshakeBadMagic:
+		return "bad magic number"
+	case HandshakeUnknownCodec:
+		return "unknown codec"
+	case HandshakeUnsupportedVersion:
+		return "unsupported protocol version"
+	case HandshakeMalformed:
+		return "malformed handshake"
+	default:
+		return fmt.Sprintf("handshake code %d", int(c))
+	}
+}
+
+// OptionAck is the server's answer to an Option
+type OptionAck struct {
+	Accepted     bool
+	CodecType    CodecEnum
+	Version      int
+	Capabilities Capabilities
+	Code         HandshakeCode // why the Option was rejected, HandshakeOK if Accepted
+	Error        string
+}
+
+// HandshakeError is returned when the server rejects the Option of a connection
+type HandshakeError struct {
+	Code    HandshakeCode
+	Message string
+}
+
+func (e *HandshakeError) Error() string {
+	if e.Message == "" {
+		return "rpc: handshake rejected: " + e.Code.String()
+	}
+	return "rpc: handshake rejected: " + e.Code.String() + ": " + e.Message
+}
+
+// Is makes errors.Is(err, &HandshakeError{Code: c}) match on the code alone
+func (e *HandshakeError) Is(target error) bool {
+	var t *HandshakeError
+	if !errors.As(target, &t) {
+		return false
+	}
+	return t.Code == e.Code && (t.Message == "" || t.Message == e.Message)
+}
+
+/*
+NegotiateVersion
+picks the version both sides speak, a client version of 0 predates the field
+and is treated as MinProtocolVersion.
+*/
+func NegotiateVersion(clientVersion int) (int, error) {
+	if clientVersion == 0 {
+		clientVersion = MinProtocolVersion
+	}
+	if clientVersion < MinProtocolVersion {
+		return 0, &HandshakeError{
+			Code:    HandshakeUnsupportedVersion,
+			Message: fmt.Sprintf("client version %d, server accepts %d..%d", clientVersion, MinProtocolVersion, ProtocolVersion),
+		}
+	}
+	return min(clientVersion, ProtocolVersion), nil
+}
+
+// AckError turns a rejected OptionAck into a *HandshakeError, it returns nil for an accepted one
+func AckError(ack *OptionAck) error {
+	if ack.Accepted {
+		return nil
+	}
+	code := ack.Code
+	if code == HandshakeOK {
+		code = HandshakeMalformed
+	}
+	return &HandshakeError{Code: code, Message: ack.Error}
+}
+
+func WriteOption(w io.Writer, option *Option) error {
+	return writeHandshake(w, option)
+}
+
+func ReadOption(r io.Reader, option *Option) error {
+	return readHandshake(r, option)
+}
+
+func WriteOptionAck(w io.Writer, ack *OptionAck) error {
+	return writeHandshake(w, ack)
+}
+
+func ReadOptionAck(r io.Reader, ack *OptionAck) error {
+	return readHandshake(r, ack)
+}
+
+func writeHandshake(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return WriteFrame(w, &Frame{Flags: FlagHandshake, Header: data}, maxHandshakeFrameSize)
+}
+
+func readHandshake(r io.Reader, v any) error {
+	frame, err := ReadFrame(r, maxHandshakeFrameSize)
+	if err != nil {
+		return err
+	}
+	if frame.Flags&FlagHandshake == 0 {
+		return fmt.Errorf("%w: expected a handshake frame", ErrMalformedFrame)
+	}
+	return json.Unmarshal(frame.Header, v)
+}
@@ -0,0 +1,33 @@
+package HastenProtocol
+
+import (
+	"encoding/json"
+	"io"
+)
+
+/*
+JsonCodec
+the header and the body of a frame are plain json documents, which keeps the
+protocol reachable from non-Go tooling.
+*/
+type JsonCodec struct {
+	*frameCodec
+}
+
+var _ RpcCodec = (*JsonCodec)(nil)
+
+func NewJsonCodec(conn io.ReadWriteCloser) RpcCodec {
+	return &JsonCodec{
+		frameCodec: newFrameCodec("json", conn, jsonSerializer{}),
+	}
+}
+
+type jsonSerializer struct{}
+
+func (jsonSerializer) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonSerializer) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,110 @@
+package HastenProtocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+type jsonOperands struct {
+	A int
+	B int
+}
+
+func TestJsonCodec(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	client, server := NewJsonCodec(clientConn), NewJsonCodec(serverConn)
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		_ = client.Write(&RpcProtocol{
+			Header: &Header{StructMethod: "ComputeS1.Add", Seq: 1},
+			Body:   &jsonOperands{A: 1, B: 2},
+		})
+		_ = client.Write(&RpcProtocol{
+			Header: &Header{StructMethod: "ComputeS1.Add", Seq: 2},
+			Body:   &jsonOperands{A: 3, B: 4},
+		})
+	}()
+
+	// the first body is discarded, the second one must still line up with its header
+	var header Header
+	if err := server.ReadHeader(&header); err != nil || header.Seq != 1 {
+		t.Fatalf("read header: %v %+v", err, header)
+	}
+	if err := server.ReadBody(nil); err != nil {
+		t.Fatalf("discard body: %v", err)
+	}
+
+	var body jsonOperands
+	if err := server.ReadHeader(&header); err != nil || header.Seq != 2 {
+		t.Fatalf("read header: %v %+v", err, header)
+	}
+	if err := server.ReadBody(&body); err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	if body.A != 3 || body.B != 4 {
+		t.Fatalf("unexpected body: %+v", body)
+	}
+}
+
+func TestMetadataLimit(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	client, server := NewJsonCodec(clientConn), NewJsonCodec(serverConn)
+	defer client.Close()
+	defer server.Close()
+
+	tooLarge := Metadata{"token": strings.Repeat("x", MaxMetadataSize)}
+	err := client.Write(&RpcProtocol{Header: &Header{Seq: 1, Metadata: tooLarge}})
+	if !errors.Is(err, ErrMetadataTooLarge) || CodeOf(err) != CodeResourceExhausted {
+		t.Fatalf("expected ErrMetadataTooLarge on write, got %v", err)
+	}
+
+	// a peer without the check, the header must still be decoded
+	go func() {
+		data, _ := json.Marshal(&Header{Seq: 2, Metadata: tooLarge})
+		_ = WriteFrame(clientConn, &Frame{Header: data}, 0)
+	}()
+
+	var header Header
+	if err = server.ReadHeader(&header); !errors.Is(err, ErrMetadataTooLarge) || header.Seq != 2 {
+		t.Fatalf("expected ErrMetadataTooLarge for seq 2, got %v %d", err, header.Seq)
+	}
+}
+
+func TestCodecDoesNotLogMetadata(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	clientConn, serverConn := net.Pipe()
+	client, server := NewJsonCodec(clientConn), NewJsonCodec(serverConn)
+	defer client.Close()
+	defer server.Close()
+
+	const token = "Bearer s3cr3t-t0k3n"
+	go func() {
+		_ = client.Write(&RpcProtocol{
+			Header: &Header{StructMethod: "ComputeS1.Add", Seq: 1, Metadata: Metadata{"authorization": token}},
+			Body:   &jsonOperands{A: 1, B: 2},
+		})
+	}()
+
+	var header Header
+	if err := server.ReadHeader(&header); err != nil || header.Metadata.Get("authorization") != token {
+		t.Fatalf("read header: %v %+v", err, header)
+	}
+	var body jsonOperands
+	if err := server.ReadBody(&body); err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	if strings.Contains(logged.String(), "s3cr3t") {
+		t.Fatalf("the metadata was logged: %s", logged.String())
+	}
+}
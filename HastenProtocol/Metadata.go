@@ -0,0 +1,62 @@
+package HastenProtocol
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+Metadata
+travels in the header of requests and responses, e.g. auth tokens, trace IDs or
+tenant IDs. The codecs refuse headers whose metadata exceeds MaxMetadataSize.
+*/
+type Metadata map[string]string
+
+const MaxMetadataSize = 8 << 10 // bytes of all keys and values together
+
+var ErrMetadataTooLarge = errors.New("rpc: metadata too large")
+
+func (md Metadata) Get(key string) string {
+	return md[key]
+}
+
+// Clone returns a copy of md which can be changed without affecting md
+func (md Metadata) Clone() Metadata {
+	if md == nil {
+		return nil
+	}
+	clone := make(Metadata, len(md))
+	for key, value := range md {
+		clone[key] = value
+	}
+	return clone
+}
+
+// Size is the number of bytes of all keys and values
+func (md Metadata) Size() int {
+	size := 0
+	for key, value := range md {
+		size += len(key) + len(value)
+	}
+	return size
+}
+
+// checkMetadata reports metadata beyond MaxMetadataSize as a CodeResourceExhausted Status wrapping ErrMetadataTooLarge
+func checkMetadata(md Metadata) error {
+	if size := md.Size(); size > MaxMetadataSize {
+		return &metadataError{size: size}
+	}
+	return nil
+}
+
+type metadataError struct {
+	size int
+}
+
+func (e *metadataError) Error() string {
+	return fmt.Sprintf("%v: %d bytes, limit %d", ErrMetadataTooLarge, e.size, MaxMetadataSize)
+}
+
+func (e *metadataError) Unwrap() []error {
+	return []error{ErrMetadataTooLarge, NewStatus(CodeResourceExhausted, e.Error())}
+}
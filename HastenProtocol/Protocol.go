@@ -1,14 +1,19 @@
 package HastenProtocol
 
 import (
+	"errors"
+	"fmt"
 	"io"
-	"net"
+	"sync"
 )
 
 type Header struct {
 	StructMethod string
-	Error        string
-	Seq          uint64 // identify each request
+	Status       *Status   // the outcome of the request, nil in requests and in successful responses
+	Seq          uint64    // identify each request
+	Deadline     int64     // unix nanoseconds after which the caller gives up, 0 means no deadline
+	Metadata     Metadata  // request metadata from the caller, response metadata from the handler
+	Flags        FrameFlag `json:"-"` // carried by the frame, every codec serializes the header without it
 }
 
 type RpcProtocol struct {
@@ -36,22 +41,68 @@ const (
 	JsonType CodecEnum = "application/json"
 )
 
-func CodecFactory(conn net.Conn, serializerType CodecEnum) (RpcCodec, error) {
-	switch serializerType {
-	case GobType:
-		return NewGobCodec(conn), nil
-	case JsonType:
-		panic("JsonType not implemented yet")
-	default:
-		panic("Unknown serializer type")
+// CodecConstructor builds an RpcCodec on top of an established connection
+type CodecConstructor func(conn io.ReadWriteCloser) RpcCodec
+
+var (
+	ErrUnknownCodec    = errors.New("rpc: unknown codec type")
+	ErrCodecRegistered = errors.New("rpc: codec type already registered")
+)
+
+var (
+	codecLock sync.RWMutex
+	codecMap  = map[CodecEnum]CodecConstructor{
+		GobType:  NewGobCodec,
+		JsonType: NewJsonCodec,
+	}
+)
+
+/*
+RegisterCodec
+makes a codec available to both the RpcServer and the client under codecType,
+the gob and json codecs are registered by default.
+*/
+func RegisterCodec(codecType CodecEnum, constructor CodecConstructor) error {
+	if codecType == "" || constructor == nil {
+		return errors.New("rpc: codec type and constructor must not be empty")
+	}
+
+	codecLock.Lock()
+	defer codecLock.Unlock()
+
+	if _, ok := codecMap[codecType]; ok {
+		return fmt.Errorf("%w: %s", ErrCodecRegistered, codecType)
+	}
+	codecMap[codecType] = constructor
+	return nil
+}
+
+// IsCodecRegistered reports whether CodecFactory is able to build codecType
+func IsCodecRegistered(codecType CodecEnum) bool {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+
+	_, ok := codecMap[codecType]
+	return ok
+}
+
+func CodecFactory(conn io.ReadWriteCloser, serializerType CodecEnum) (RpcCodec, error) {
+	codecLock.RLock()
+	constructor, ok := codecMap[serializerType]
+	codecLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCodec, serializerType)
 	}
+	return constructor(conn), nil
 }
 
 /*------------*/
 
 type Option struct {
 	MagicNumber int
-	CodecType   CodecEnum // supporting only the gob for now
+	CodecType   CodecEnum // any codec known to RegisterCodec
+	Version     int       // the highest protocol version the client speaks
 }
 
 const DefaultMagicNumber = 0x3bef5c
@@ -59,4 +110,5 @@ const DefaultMagicNumber = 0x3bef5c
 var DefaultOption = Option{
 	MagicNumber: DefaultMagicNumber,
 	CodecType:   GobType,
+	Version:     ProtocolVersion,
 }
@@ -0,0 +1,78 @@
+package HastenProtocol
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+// unregisterCodec undoes RegisterCodec, so the tests leave the registry as they found it
+func unregisterCodec(codecType CodecEnum) {
+	codecLock.Lock()
+	defer codecLock.Unlock()
+	delete(codecMap, codecType)
+}
+
+func TestCodecFactory(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+
+	if _, err := CodecFactory(conn, "application/unknown"); !errors.Is(err, ErrUnknownCodec) {
+		t.Fatalf("expected ErrUnknownCodec, got %v", err)
+	}
+
+	if err := RegisterCodec(GobType, NewGobCodec); !errors.Is(err, ErrCodecRegistered) {
+		t.Fatalf("expected ErrCodecRegistered, got %v", err)
+	}
+
+	const customType CodecEnum = "application/x-custom-json"
+	if err := RegisterCodec(customType, NewJsonCodec); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { unregisterCodec(customType) })
+	codec, err := CodecFactory(conn, customType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := codec.(*JsonCodec); !ok {
+		t.Fatalf("unexpected codec %T", codec)
+	}
+}
+
+func TestFlagsStayInTheFrame(t *testing.T) {
+	tests := []struct {
+		codecType  CodecEnum
+		serializer Serializer
+	}{
+		{GobType, gobSerializer{}},
+		{JsonType, jsonSerializer{}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.codecType), func(t *testing.T) {
+			conn, peer := net.Pipe()
+			defer conn.Close()
+			defer peer.Close()
+			codec, _ := CodecFactory(conn, tt.codecType)
+
+			go func() {
+				_ = codec.Write(&RpcProtocol{Header: &Header{Seq: 7, Flags: FlagCancel}})
+			}()
+			frame, err := ReadFrame(peer, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if frame.Flags != FlagCancel {
+				t.Fatalf("expected the frame to carry FlagCancel, got %v", frame.Flags)
+			}
+
+			var header Header
+			if err = tt.serializer.Unmarshal(frame.Header, &header); err != nil {
+				t.Fatal(err)
+			}
+			if header.Seq != 7 || header.Flags != 0 {
+				t.Fatalf("the serialized header carries flags: %+v", header)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package HastenProtocol
+
+import (
+	"reflect"
+	"strings"
+)
+
+/*
+ReflectionServiceName
+is the service every RpcServer registers to describe itself:
+
+	ReflectionServiceName + ".ListServices"    args: string prefix     reply: ListServicesReply
+	ReflectionServiceName + ".DescribeService" args: string service    reply: ServiceDescriptor
+*/
+const ReflectionServiceName = "Hasten.Reflection"
+
+const (
+	ListServicesMethod    = ReflectionServiceName + ".ListServices"
+	DescribeServiceMethod = ReflectionServiceName + ".DescribeService"
+)
+
+type ListServicesReply struct {
+	Services []ServiceDescriptor // sorted by name
+}
+
+type ServiceDescriptor struct {
+	Name    string
+	Methods []MethodDescriptor // sorted by name
+}
+
+type MethodDescriptor struct {
+	Name         string
+	StructMethod string // what to pass to Call, e.g. "ComputeS1.Add"
+	Args         *TypeSchema
+	Reply        *TypeSchema // the type the reply pointer points to
+}
+
+/*
+TypeSchema
+describes a Go type the way a caller without the Go type needs it, Kind is the
+reflect.Kind, e.g. "struct", "int" or "slice". Pointers are transparent, a *T is
+described as T. A type which refers to itself is only expanded the first time,
+later references carry Name and Kind only.
+*/
+type TypeSchema struct {
+	Name   string // e.g. "HastenServer.TwoOperands", empty for unnamed types
+	Kind   string
+	Fields []FieldSchema // of a struct
+	Elem   *TypeSchema   // of an array, slice or map
+	Key    *TypeSchema   // of a map
+}
+
+type FieldSchema struct {
+	Name     string
+	JSONName string // the name used by the json codec
+	Type     *TypeSchema
+}
+
+// SchemaOf describes t, see TypeSchema
+func SchemaOf(t reflect.Type) *TypeSchema {
+	return schemaOf(t, make(map[reflect.Type]bool))
+}
+
+func schemaOf(t reflect.Type, expanding map[reflect.Type]bool) *TypeSchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	schema := &TypeSchema{Name: t.String(), Kind: t.Kind().String()}
+	if t.Name() == "" {
+		schema.Name = ""
+	}
+	if expanding[t] {
+		return schema
+	}
+	expanding[t] = true
+	defer delete(expanding, t)
+
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			jsonName := jsonFieldName(field)
+			if !field.IsExported() || jsonName == "-" {
+				continue
+			}
+			schema.Fields = append(schema.Fields, FieldSchema{
+				Name:     field.Name,
+				JSONName: jsonName,
+				Type:     schemaOf(field.Type, expanding),
+			})
+		}
+	case reflect.Array, reflect.Slice:
+		schema.Elem = schemaOf(t.Elem(), expanding)
+	case reflect.Map:
+		schema.Key = schemaOf(t.Key(), expanding)
+		schema.Elem = schemaOf(t.Elem(), expanding)
+	}
+	return schema
+}
+
+// jsonFieldName returns the name encoding/json uses for field, "-" if it is skipped
+func jsonFieldName(field reflect.StructField) string {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "-"
+	}
+	if name, _, _ := strings.Cut(tag, ","); name != "" {
+		return name
+	}
+	return field.Name
+}
@@ -0,0 +1,42 @@
+package HastenProtocol
+
+import (
+	"reflect"
+	"testing"
+)
+
+type treeNode struct {
+	Value    int    `json:"value"`
+	Label    string `json:"label,omitempty"`
+	Secret   string `json:"-"`
+	hidden   bool
+	Children []*treeNode
+	Attrs    map[string]float64
+}
+
+func TestSchemaOf(t *testing.T) {
+	schema := SchemaOf(reflect.TypeOf(&treeNode{}))
+	if schema.Name != "HastenProtocol.treeNode" || schema.Kind != "struct" {
+		t.Fatalf("unexpected schema: %+v", schema)
+	}
+
+	want := []string{"value", "label", "Children", "Attrs"}
+	if len(schema.Fields) != len(want) {
+		t.Fatalf("expected fields %v, got %+v", want, schema.Fields)
+	}
+	for i, field := range schema.Fields {
+		if field.JSONName != want[i] {
+			t.Fatalf("field %d: expected %s, got %s", i, want[i], field.JSONName)
+		}
+	}
+
+	// the recursion stops at the second treeNode
+	children := schema.Fields[2].Type
+	if children.Kind != "slice" || children.Elem.Name != "HastenProtocol.treeNode" || children.Elem.Fields != nil {
+		t.Fatalf("unexpected children schema: %+v", children.Elem)
+	}
+	attrs := schema.Fields[3].Type
+	if attrs.Kind != "map" || attrs.Key.Kind != "string" || attrs.Elem.Kind != "float64" || attrs.Name != "" {
+		t.Fatalf("unexpected attrs schema: %+v", attrs)
+	}
+}
@@ -0,0 +1,121 @@
+package HastenProtocol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+/*
+Status
+is the outcome of a request as carried by the response header. A *Status is an
+error, so the handlers return it and the clients inspect it with errors.As or
+compare codes with errors.Is(err, &Status{Code: CodeNotFound}).
+*/
+type Status struct {
+	Code    ErrorCode
+	Message string
+	Details []StatusDetail
+}
+
+// StatusDetail is a typed payload attached to a Status, Value is the json encoding of a value of type Type
+type StatusDetail struct {
+	Type  string
+	Value json.RawMessage
+}
+
+func NewStatus(code ErrorCode, message string) *Status {
+	return &Status{Code: code, Message: message}
+}
+
+func Errorf(code ErrorCode, format string, a ...any) *Status {
+	return NewStatus(code, fmt.Sprintf(format, a...))
+}
+
+func (s *Status) Error() string {
+	return fmt.Sprintf("rpc error: code = %s desc = %s", s.Code, s.Message)
+}
+
+// Is matches a target *Status with the same code, a target with a message must match that as well
+func (s *Status) Is(target error) bool {
+	var t *Status
+	if !errors.As(target, &t) {
+		return false
+	}
+	return t.Code == s.Code && (t.Message == "" || t.Message == s.Message)
+}
+
+// WithDetails returns a copy of s which carries details as well
+func (s *Status) WithDetails(details ...any) (*Status, error) {
+	withDetails := *s
+	withDetails.Details = append([]StatusDetail(nil), s.Details...)
+
+	for _, detail := range details {
+		value, err := json.Marshal(detail)
+		if err != nil {
+			return nil, err
+		}
+		withDetails.Details = append(withDetails.Details, StatusDetail{
+			Type:  detailType(reflect.TypeOf(detail)),
+			Value: value,
+		})
+	}
+	return &withDetails, nil
+}
+
+// DecodeDetail decodes the first detail of the type v points to into v, it reports whether there was one
+func (s *Status) DecodeDetail(v any) bool {
+	t := reflect.TypeOf(v)
+	if t == nil || t.Kind() != reflect.Ptr {
+		return false
+	}
+
+	name := detailType(t.Elem())
+	for _, detail := range s.Details {
+		if detail.Type == name {
+			return json.Unmarshal(detail.Value, v) == nil
+		}
+	}
+	return false
+}
+
+func detailType(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.String()
+}
+
+/*
+StatusFromError
+converts any error into a Status: a *Status in the chain of err is returned as
+it is, the context errors get their own codes and everything else is unknown.
+A nil err gives nil.
+*/
+func StatusFromError(err error) *Status {
+	if err == nil {
+		return nil
+	}
+
+	var s *Status
+	switch {
+	case errors.As(err, &s):
+		return s
+	case errors.Is(err, context.DeadlineExceeded):
+		return NewStatus(CodeDeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return NewStatus(CodeCanceled, err.Error())
+	default:
+		return NewStatus(CodeUnknown, err.Error())
+	}
+}
+
+// CodeOf returns the code of err, CodeOK for a nil err
+func CodeOf(err error) ErrorCode {
+	if err == nil {
+		return CodeOK
+	}
+	return StatusFromError(err).Code
+}
@@ -0,0 +1,48 @@
+package HastenProtocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type quotaDetail struct {
+	Limit int
+}
+
+func TestStatus(t *testing.T) {
+	status, err := NewStatus(CodeResourceExhausted, "too many calls").WithDetails(&quotaDetail{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped := fmt.Errorf("calling ComputeS1.Add: %w", status)
+
+	if !errors.Is(wrapped, &Status{Code: CodeResourceExhausted}) {
+		t.Fatal("errors.Is does not match on the code")
+	}
+	if errors.Is(wrapped, &Status{Code: CodeNotFound}) {
+		t.Fatal("errors.Is matches a different code")
+	}
+
+	var quota quotaDetail
+	if !StatusFromError(wrapped).DecodeDetail(&quota) || quota.Limit != 10 {
+		t.Fatalf("decode detail: %+v", quota)
+	}
+
+	tests := []struct {
+		err  error
+		code ErrorCode
+	}{
+		{nil, CodeOK},
+		{wrapped, CodeResourceExhausted},
+		{context.DeadlineExceeded, CodeDeadlineExceeded},
+		{context.Canceled, CodeCanceled},
+		{errors.New("boom"), CodeUnknown},
+	}
+	for _, tt := range tests {
+		if code := CodeOf(tt.err); code != tt.code {
+			t.Fatalf("CodeOf(%v) = %v, want %v", tt.err, code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package HastenRegistry
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Endpoint is one address an instance serves on, e.g. {"tcp", "10.0.0.7:12312"} or {"unix", "/run/compute.sock"}
+type Endpoint struct {
+	Network string
+	Address string
+}
+
+// EndpointOf returns the endpoint of a listener address, e.g. listener.Addr()
+func EndpointOf(addr net.Addr) Endpoint {
+	return Endpoint{Network: addr.Network(), Address: addr.String()}
+}
+
+/*
+ParseEndpoint
+accepts "network://address" as written by String, a bare "host:port" is a tcp
+endpoint.
+*/
+func ParseEndpoint(s string) (Endpoint, error) {
+	network, address, ok := strings.Cut(s, "://")
+	if !ok {
+		network, address = "tcp", s
+	}
+	endpoint := Endpoint{Network: network, Address: address}
+	return endpoint, endpoint.Validate()
+}
+
+func (e Endpoint) String() string {
+	return e.Network + "://" + e.Address
+}
+
+/*
+Validate
+makes sure a client can dial e: a tcp endpoint needs a host other than the
+unspecified one and a port, a unix endpoint needs a path.
+*/
+func (e Endpoint) Validate() error {
+	switch e.Network {
+	case "tcp", "tcp4", "tcp6":
+		host, port, err := net.SplitHostPort(e.Address)
+		if err != nil {
+			return fmt.Errorf("endpoint %s: %w", e, err)
+		}
+		if host == "" || net.ParseIP(host).IsUnspecified() {
+			return fmt.Errorf("endpoint %s: host must be dialable, not %q", e, host)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			return fmt.Errorf("endpoint %s: invalid port %q", e, port)
+		}
+	case "unix":
+		if e.Address == "" {
+			return fmt.Errorf("endpoint %s: empty socket path", e)
+		}
+	default:
+		return fmt.Errorf("endpoint %s: unsupported network %q", e, e.Network)
+	}
+	return nil
+}
+
+// validateEndpoints is what the registry checks before it registers an instance
+func validateEndpoints(endpoints []Endpoint) error {
+	if len(endpoints) == 0 {
+		return fmt.Errorf("no endpoint to advertise")
+	}
+	for _, endpoint := range endpoints {
+		if err := endpoint.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
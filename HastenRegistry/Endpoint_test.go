@@ -0,0 +1,34 @@
+package HastenRegistry
+
+import "testing"
+
+func TestParseEndpoint(t *testing.T) {
+	tests := []struct {
+		in    string
+		want  Endpoint
+		valid bool
+	}{
+		{"127.0.0.1:12312", Endpoint{"tcp", "127.0.0.1:12312"}, true},
+		{"tcp://compute.internal:80", Endpoint{"tcp", "compute.internal:80"}, true},
+		{"tcp6://[::1]:80", Endpoint{"tcp6", "[::1]:80"}, true},
+		{"unix:///run/compute.sock", Endpoint{"unix", "/run/compute.sock"}, true},
+		{"0.0.0.0:12312", Endpoint{"tcp", "0.0.0.0:12312"}, false},
+		{"[::]:12312", Endpoint{"tcp", "[::]:12312"}, false},
+		{":12312", Endpoint{"tcp", ":12312"}, false},
+		{"127.0.0.1", Endpoint{"tcp", "127.0.0.1"}, false},
+		{"127.0.0.1:0", Endpoint{"tcp", "127.0.0.1:0"}, false},
+		{"unix://", Endpoint{"unix", ""}, false},
+		{"udp://127.0.0.1:53", Endpoint{"udp", "127.0.0.1:53"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			endpoint, err := ParseEndpoint(tt.in)
+			if endpoint != tt.want || (err == nil) != tt.valid {
+				t.Fatalf("ParseEndpoint(%q) = %v %v, want %v valid %v", tt.in, endpoint, err, tt.want, tt.valid)
+			}
+			if again, _ := ParseEndpoint(endpoint.String()); again != endpoint {
+				t.Fatalf("%q does not parse back into %v", endpoint.String(), endpoint)
+			}
+		})
+	}
+}
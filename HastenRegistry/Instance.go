@@ -0,0 +1,76 @@
+package HastenRegistry
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Instance is one registered server of a service, reachable on any of its endpoints
+type Instance struct {
+	ID           string // unique within the service, generated by the registry when empty
+	Endpoints    []Endpoint
+	Weight       int    // relative share of the traffic, 0 means DefaultWeight
+	Version      string // e.g. "v2"
+	Zone         string
+	Region       string
+	Tags         map[string]string
+	RegisteredAt time.Time // set by the registry
+}
+
+const DefaultWeight = 1
+
+/*
+Filter
+narrows a Discovery down to the instances whose Version, Zone and Region equal
+the non-empty fields of the filter and which carry all of its Tags. The zero
+Filter matches every instance.
+*/
+type Filter struct {
+	Version string
+	Zone    string
+	Region  string
+	Tags    map[string]string
+}
+
+func (f *Filter) Matches(instance *Instance) bool {
+	if f == nil {
+		return true
+	}
+	if (f.Version != "" && f.Version != instance.Version) ||
+		(f.Zone != "" && f.Zone != instance.Zone) ||
+		(f.Region != "" && f.Region != instance.Region) {
+		return false
+	}
+	for key, value := range f.Tags {
+		if tag, ok := instance.Tags[key]; !ok || tag != value {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeInstance validates instance and fills in what the registry decides, see Instance
+func normalizeInstance(instance *Instance, now time.Time) error {
+	if err := validateEndpoints(instance.Endpoints); err != nil {
+		return err
+	}
+	if instance.Weight < 0 {
+		return fmt.Errorf("negative weight %d", instance.Weight)
+	}
+	if instance.Weight == 0 {
+		instance.Weight = DefaultWeight
+	}
+	if instance.ID == "" {
+		instance.ID = newInstanceID()
+	}
+	instance.RegisteredAt = now
+	return nil
+}
+
+func newInstanceID() string {
+	var id [8]byte
+	_, _ = rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
@@ -0,0 +1,140 @@
+package HastenRegistry
+
+import (
+	"net"
+	"time"
+)
+
+const (
+	DefaultTTL = 5 * time.Second // of a Registry which asks for no TTL
+	MinTTL     = time.Second
+	MaxTTL     = 5 * time.Minute
+)
+
+// Clock is where the registry takes the time from, tests replace it with a fake one
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// RegistryOption configures a RegistryServer in StartRegistryServer
+type RegistryOption func(r *RegistryServer)
+
+// WithClock replaces the clock the leases expire by
+func WithClock(clock Clock) RegistryOption {
+	return func(r *RegistryServer) {
+		r.clock = clock
+	}
+}
+
+// WithReapInterval sets how often expired leases are looked for, by default MinTTL / 2
+func WithReapInterval(interval time.Duration) RegistryOption {
+	return func(r *RegistryServer) {
+		if interval > 0 {
+			r.reapInterval = interval
+		}
+	}
+}
+
+/*
+lease
+keeps one registered instance alive until expiresAt, every heartbeat moves
+expiresAt another ttl ahead. The instance is removed once the lease expires,
+whether or not its registration connection is still open.
+*/
+type lease struct {
+	id          string
+	serviceName string
+	instanceID  string
+	ttl         time.Duration
+	expiresAt   time.Time
+	conn        net.Conn // the registration connection, closed when the lease expires
+}
+
+// clampTTL turns the TTL a client asked for into the one it is granted
+func clampTTL(ttl time.Duration) time.Duration {
+	switch {
+	case ttl == 0:
+		return DefaultTTL
+	case ttl < MinTTL:
+		return MinTTL
+	case ttl > MaxTTL:
+		return MaxTTL
+	}
+	return ttl
+}
+
+// grantLease is called with r.lock held, right after the instance has been added
+func (r *RegistryServer) grantLease(serviceName string, instanceID string, ttl time.Duration, conn net.Conn) *lease {
+	l := &lease{
+		id:          newInstanceID(),
+		serviceName: serviceName,
+		instanceID:  instanceID,
+		ttl:         clampTTL(ttl),
+		conn:        conn,
+	}
+	l.expiresAt = r.clock.Now().Add(l.ttl)
+	r.leases[l.id] = l
+	return l
+}
+
+// renewLease moves the expiry of leaseID one ttl ahead, an expired lease is gone for good
+func (r *RegistryServer) renewLease(leaseID string) (StatusCode, string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	l, ok := r.leases[leaseID]
+	if !ok {
+		return StatusNotFound, "lease " + leaseID + " not found or expired"
+	}
+	l.expiresAt = r.clock.Now().Add(l.ttl)
+	return StatusOK, ""
+}
+
+// revokeLease ends leaseID at once and removes its instance
+func (r *RegistryServer) revokeLease(leaseID string) (StatusCode, string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	l, ok := r.leases[leaseID]
+	if !ok {
+		return StatusNotFound, "lease " + leaseID + " not found or expired"
+	}
+	delete(r.leases, leaseID)
+	r.removeInstanceLocked(l.serviceName, l.instanceID)
+	return StatusOK, ""
+}
+
+// reap removes the instances whose lease expired, the watchers see an EventRemove for each of them
+func (r *RegistryServer) reap() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := r.clock.Now()
+	for id, l := range r.leases {
+		if now.Before(l.expiresAt) {
+			continue
+		}
+		delete(r.leases, id)
+		r.removeInstanceLocked(l.serviceName, l.instanceID)
+		if l.conn != nil {
+			_ = l.conn.Close()
+		}
+	}
+}
+
+func (r *RegistryServer) runReaper() {
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-r.clock.After(r.reapInterval):
+			r.reap()
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package HastenRegistry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves on Advance, the reaper is its only waiter
+type fakeClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	w := fakeWaiter{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.waiters = append(c.waiters, w)
+	return w.c
+}
+
+/*
+Advance
+moves the clock by d and returns once the reaper went through the leases and
+waits again, so whatever expired by then is gone.
+*/
+func (c *fakeClock) Advance(t *testing.T, d time.Duration) {
+	c.waitForWaiter(t)
+
+	c.lock.Lock()
+	c.now = c.now.Add(d)
+	remained := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			remained = append(remained, w)
+		} else {
+			w.c <- c.now
+		}
+	}
+	c.waiters = remained
+	c.lock.Unlock()
+
+	c.waitForWaiter(t)
+}
+
+func (c *fakeClock) waitForWaiter(t *testing.T) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		c.lock.Lock()
+		n := len(c.waiters)
+		c.lock.Unlock()
+		if n > 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("the reaper does not wait on the clock")
+}
+
+func TestLease(t *testing.T) {
+	clock := newFakeClock()
+	client := NewRegistryClient(startTestRegistry(t, WithClock(clock), WithReapInterval(time.Second)))
+	ctx := context.Background()
+	endpoints := []Endpoint{{"tcp", "10.0.0.7:12312"}}
+
+	reg, err := client.RegisterWithTTL(ctx, "ComputeS1", Instance{ID: "compute-1", Endpoints: endpoints}, 3*time.Second)
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	defer reg.Close()
+	if reg.LeaseID == "" || reg.TTL != 3*time.Second || reg.HeartbeatInterval() != time.Second {
+		t.Fatalf("unexpected lease %q ttl %v", reg.LeaseID, reg.TTL)
+	}
+
+	watcher, err := client.Watch(ctx, "ComputeS1", nil)
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	defer watcher.Close()
+
+	// each renewal moves the expiry a ttl ahead of it
+	clock.Advance(t, 2*time.Second)
+	if err = reg.Heartbeat(ctx); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	clock.Advance(t, 2*time.Second)
+	if err = client.Renew(ctx, reg.LeaseID); err != nil {
+		t.Fatalf("renew: %v", err)
+	}
+	clock.Advance(t, 2*time.Second)
+	if _, err = client.Discover(ctx, "ComputeS1", nil); err != nil {
+		t.Fatalf("discover a renewed instance: %v", err)
+	}
+
+	clock.Advance(t, time.Second)
+	event, err := watcher.Next()
+	if err != nil {
+		t.Fatalf("next: %v", err)
+	}
+	if event.Type != EventRemove || event.Instance.ID != "compute-1" {
+		t.Fatalf("expected the removal of compute-1, got %+v", event)
+	}
+	if _, err = client.Discover(ctx, "ComputeS1", nil); !errors.Is(err, ErrServiceNotFound) {
+		t.Fatalf("expected ErrServiceNotFound after expiry, got %v", err)
+	}
+	if err = client.Renew(ctx, reg.LeaseID); !errors.Is(err, ErrServiceNotFound) {
+		t.Fatalf("expected ErrServiceNotFound renewing an expired lease, got %v", err)
+	}
+	if err = reg.Heartbeat(ctx); err == nil {
+		t.Fatal("expected a heartbeat on an expired lease to fail")
+	}
+}
+
+func TestLeaseOutlivesConnection(t *testing.T) {
+	clock := newFakeClock()
+	client := NewRegistryClient(startTestRegistry(t, WithClock(clock), WithReapInterval(time.Second)))
+	ctx := context.Background()
+
+	reg, err := client.Register(ctx, "ComputeS1", Instance{Endpoints: []Endpoint{{"tcp", "10.0.0.7:12312"}}})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if reg.TTL != DefaultTTL {
+		t.Fatalf("expected DefaultTTL, got %v", reg.TTL)
+	}
+	_ = reg.Close()
+
+	clock.Advance(t, DefaultTTL-time.Second)
+	if _, err = client.Discover(ctx, "ComputeS1", nil); err != nil {
+		t.Fatalf("the instance should stay until its lease expires: %v", err)
+	}
+	clock.Advance(t, time.Second)
+	if _, err = client.Discover(ctx, "ComputeS1", nil); !errors.Is(err, ErrServiceNotFound) {
+		t.Fatalf("expected ErrServiceNotFound after expiry, got %v", err)
+	}
+}
+
+func TestRevokeLease(t *testing.T) {
+	client := NewRegistryClient(startTestRegistry(t))
+	ctx := context.Background()
+
+	reg, err := client.Register(ctx, "ComputeS1", Instance{Endpoints: []Endpoint{{"tcp", "10.0.0.7:12312"}}})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	_ = reg.Close()
+	if err = reg.Deregister(ctx); !errors.Is(err, ErrRegistrationClosed) {
+		t.Fatalf("expected ErrRegistrationClosed, got %v", err)
+	}
+
+	// the lease is revoked on a connection of its own
+	if err = client.Revoke(ctx, reg.LeaseID); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if _, err = client.Discover(ctx, "ComputeS1", nil); !errors.Is(err, ErrServiceNotFound) {
+		t.Fatalf("expected ErrServiceNotFound after revoke, got %v", err)
+	}
+	if err = client.Revoke(ctx, reg.LeaseID); !errors.Is(err, ErrServiceNotFound) {
+		t.Fatalf("expected ErrServiceNotFound revoking twice, got %v", err)
+	}
+}
+
+func TestClampTTL(t *testing.T) {
+	tests := []struct {
+		ttl  time.Duration
+		want time.Duration
+	}{
+		{0, DefaultTTL},
+		{time.Millisecond, MinTTL},
+		{10 * time.Second, 10 * time.Second},
+		{time.Hour, MaxTTL},
+	}
+	for _, tt := range tests {
+		if got := clampTTL(tt.ttl); got != tt.want {
+			t.Errorf("clampTTL(%v) = %v, want %v", tt.ttl, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package HastenRegistry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// RequestTimeout bounds a request to the registry whose ctx has no deadline
+var RequestTimeout = 5 * time.Second
+
+var ErrRegistrationClosed = errors.New("registry: registration is closed")
+
+// RegistryClient talks to the RegistryServer at addr
+type RegistryClient struct {
+	addr string
+}
+
+func NewRegistryClient(registryAddr string) *RegistryClient {
+	return &RegistryClient{addr: registryAddr}
+}
+
+/*
+Discover
+returns the instances of serviceName which match filter, a nil filter matches
+all of them. Finding no instance fails with ErrServiceNotFound.
+*/
+func (c *RegistryClient) Discover(ctx context.Context, serviceName string, filter *Filter) ([]Instance, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var resp DiscoveryResp
+	err = roundTrip(ctx, conn, json.NewEncoder(conn), json.NewDecoder(conn),
+		&RegistryReq{ServiceName: serviceName, OpType: Discovery, Filter: filter}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if err = statusError(resp.Code, resp.Message); err != nil {
+		return nil, err
+	}
+	return resp.Instances, nil
+}
+
+/*
+Register
+adds instance to the instances of serviceName with a lease of DefaultTTL, the
+registry refuses endpoints a client could not dial. The instance stays
+registered as long as Heartbeat is called every Registration.HeartbeatInterval,
+until Deregister.
+*/
+func (c *RegistryClient) Register(ctx context.Context, serviceName string, instance Instance) (*Registration, error) {
+	return c.RegisterWithTTL(ctx, serviceName, instance, 0)
+}
+
+// RegisterWithTTL is Register asking for a lease of ttl, the registry clamps it to [MinTTL, MaxTTL]
+func (c *RegistryClient) RegisterWithTTL(
+	ctx context.Context, serviceName string, instance Instance, ttl time.Duration) (*Registration, error) {
+
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reg := &Registration{
+		ServiceName: serviceName,
+		Instance:    instance,
+		conn:        conn,
+		encoder:     json.NewEncoder(conn),
+		decoder:     json.NewDecoder(conn),
+		TTL:         ttl,
+	}
+	if err = reg.request(ctx, Registry, &reg.Instance); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return reg, nil
+}
+
+/*
+Renew
+renews the lease leaseID on a connection of its own, e.g. after the connection
+of the Registration broke. A lease which already expired fails with
+ErrServiceNotFound, the instance has to be registered again.
+*/
+func (c *RegistryClient) Renew(ctx context.Context, leaseID string) error {
+	return c.leaseRequest(ctx, HeartBeat, leaseID)
+}
+
+/*
+Revoke
+deregisters the instance of the lease leaseID on a connection of its own, e.g.
+after the connection of the Registration broke. A lease which already expired
+fails with ErrServiceNotFound, its instance is gone already.
+*/
+func (c *RegistryClient) Revoke(ctx context.Context, leaseID string) error {
+	return c.leaseRequest(ctx, Deregister, leaseID)
+}
+
+// leaseRequest sends op for leaseID on a new connection, which is closed afterwards
+func (c *RegistryClient) leaseRequest(ctx context.Context, op OpType, leaseID string) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var resp RegistryResp
+	err = roundTrip(ctx, conn, json.NewEncoder(conn), json.NewDecoder(conn),
+		&RegistryReq{OpType: op, LeaseID: leaseID}, &resp)
+	if err != nil {
+		return err
+	}
+	return statusError(resp.Code, resp.Message)
+}
+
+func (c *RegistryClient) dial(ctx context.Context) (net.Conn, error) {
+	var dialer net.Dialer
+	ctx, cancel := withRequestTimeout(ctx)
+	defer cancel()
+	return dialer.DialContext(ctx, "tcp", c.addr)
+}
+
+// Registration is one registered instance and the lease which keeps it registered
+type Registration struct {
+	ServiceName string
+	Instance    Instance      // its ID is the one assigned by the registry
+	LeaseID     string        // see RegistryClient.Renew and RegistryClient.Revoke
+	TTL         time.Duration // as granted by the registry
+
+	lock    sync.Mutex // one request at a time
+	conn    net.Conn
+	encoder *json.Encoder
+	decoder *json.Decoder
+	closed  bool
+}
+
+// Heartbeat renews the lease, the instance is removed once TTL passes without one
+func (r *Registration) Heartbeat(ctx context.Context) error {
+	return r.request(ctx, HeartBeat, nil)
+}
+
+// HeartbeatInterval is how often Heartbeat should be called, leaving room for two to get lost
+func (r *Registration) HeartbeatInterval() time.Duration {
+	return r.TTL / 3
+}
+
+/*
+Update
+replaces the registered instance, e.g. with a lower weight while draining. The
+ID and the registration time stay, watchers see an EventUpdate.
+*/
+func (r *Registration) Update(ctx context.Context, instance Instance) error {
+	return r.request(ctx, Update, &instance)
+}
+
+// Deregister removes the instance from the registry and closes the registration
+func (r *Registration) Deregister(ctx context.Context) error {
+	err := r.request(ctx, Deregister, nil)
+	_ = r.Close()
+	return err
+}
+
+// Close drops the connection, the registry removes the instance once its lease expires
+func (r *Registration) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	return r.conn.Close()
+}
+
+// request sends op with instance, once the registry accepted it the instance it stored becomes r.Instance
+func (r *Registration) request(ctx context.Context, op OpType, instance *Instance) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.closed {
+		return ErrRegistrationClosed
+	}
+
+	var resp RegistryResp
+	err := roundTrip(ctx, r.conn, r.encoder, r.decoder,
+		&RegistryReq{ServiceName: r.ServiceName, OpType: op, Instance: instance, TTL: r.TTL, LeaseID: r.LeaseID}, &resp)
+	if err != nil {
+		return err
+	}
+	if err = statusError(resp.Code, resp.Message); err != nil {
+		return err
+	}
+
+	if resp.Instance != nil {
+		r.Instance = *resp.Instance
+	}
+	if op == Registry {
+		r.LeaseID, r.TTL = resp.LeaseID, resp.TTL
+	}
+	return nil
+}
+
+// roundTrip sends req and reads resp before the deadline of ctx, or RequestTimeout without one
+func roundTrip(ctx context.Context, conn net.Conn, encoder *json.Encoder, decoder *json.Decoder, req any, resp any) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(RequestTimeout)
+	}
+	_ = conn.SetDeadline(deadline)
+	defer conn.SetDeadline(time.Time{})
+
+	if err := encoder.Encode(req); err != nil {
+		return err
+	}
+	return decoder.Decode(resp)
+}
+
+func withRequestTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, RequestTimeout)
+}
@@ -0,0 +1,135 @@
+package HastenRegistry
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func startTestRegistry(t *testing.T, opts ...RegistryOption) string {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := StartRegistryServer(opts...)
+	t.Cleanup(func() {
+		_ = listen.Close()
+		_ = registry.Close()
+	})
+
+	go registry.Serve(listen)
+	return listen.Addr().String()
+}
+
+func TestRegistryClient(t *testing.T) {
+	client := NewRegistryClient(startTestRegistry(t))
+	ctx := context.Background()
+
+	if _, err := client.Discover(ctx, "ComputeS1", nil); !errors.Is(err, ErrServiceNotFound) {
+		t.Fatalf("expected ErrServiceNotFound, got %v", err)
+	}
+
+	endpoints := []Endpoint{{"tcp", "10.0.0.7:12312"}, {"unix", "/run/compute.sock"}}
+	reg, err := client.Register(ctx, "ComputeS1", Instance{Endpoints: endpoints})
+	if err != nil || reg.Instance.ID == "" {
+		t.Fatalf("register: %v %+v", err, reg)
+	}
+	instances, err := client.Discover(ctx, "ComputeS1", nil)
+	if err != nil || len(instances) != 1 || !reflect.DeepEqual(instances[0].Endpoints, endpoints) {
+		t.Fatalf("discover: %+v %v", instances, err)
+	}
+	if got := instances[0]; got.ID != reg.Instance.ID || got.Weight != DefaultWeight || got.RegisteredAt.IsZero() {
+		t.Fatalf("unexpected instance: %+v", got)
+	}
+	if err = reg.Heartbeat(ctx); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+
+	if err = reg.Deregister(ctx); err != nil {
+		t.Fatalf("deregister: %v", err)
+	}
+	if err = reg.Heartbeat(ctx); !errors.Is(err, ErrRegistrationClosed) {
+		t.Fatalf("expected ErrRegistrationClosed, got %v", err)
+	}
+	if _, err = client.Discover(ctx, "ComputeS1", nil); !errors.Is(err, ErrServiceNotFound) {
+		t.Fatalf("expected ErrServiceNotFound after deregister, got %v", err)
+	}
+}
+
+func TestRegisterRejects(t *testing.T) {
+	client := NewRegistryClient(startTestRegistry(t))
+	ctx := context.Background()
+	valid := []Endpoint{{"tcp", "10.0.0.7:12312"}}
+
+	reg, err := client.Register(ctx, "ComputeS1", Instance{ID: "compute-1", Endpoints: valid})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	defer reg.Close()
+
+	tests := []struct {
+		name        string
+		serviceName string
+		instance    Instance
+		code        StatusCode
+	}{
+		{"empty service name", "", Instance{Endpoints: valid}, StatusBadRequest},
+		{"no endpoints", "ComputeS1", Instance{}, StatusBadRequest},
+		{"unspecified host", "ComputeS1", Instance{Endpoints: []Endpoint{{"tcp", "0.0.0.0:12312"}}}, StatusBadRequest},
+		{"negative weight", "ComputeS1", Instance{Endpoints: valid, Weight: -1}, StatusBadRequest},
+		{"duplicate id", "ComputeS1", Instance{ID: "compute-1", Endpoints: valid}, StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.Register(ctx, tt.serviceName, tt.instance)
+			if !errors.Is(err, &RegistryError{Code: tt.code}) {
+				t.Fatalf("expected %v, got %v", tt.code, err)
+			}
+		})
+	}
+}
+
+func TestDiscoverFilter(t *testing.T) {
+	client := NewRegistryClient(startTestRegistry(t))
+	ctx := context.Background()
+
+	for _, instance := range []Instance{
+		{ID: "a-v1", Version: "v1", Zone: "a", Tags: map[string]string{"canary": "false"}},
+		{ID: "a-v2", Version: "v2", Zone: "a", Tags: map[string]string{"canary": "true"}},
+		{ID: "b-v2", Version: "v2", Zone: "b", Region: "eu"},
+	} {
+		instance.Endpoints = []Endpoint{{"tcp", "10.0.0.7:12312"}}
+		reg, err := client.Register(ctx, "ComputeS1", instance)
+		if err != nil {
+			t.Fatalf("register %s: %v", instance.ID, err)
+		}
+		defer reg.Close()
+	}
+
+	tests := []struct {
+		name   string
+		filter *Filter
+		ids    []string
+	}{
+		{"all", nil, []string{"a-v1", "a-v2", "b-v2"}},
+		{"version", &Filter{Version: "v2"}, []string{"a-v2", "b-v2"}},
+		{"version and zone", &Filter{Version: "v2", Zone: "a"}, []string{"a-v2"}},
+		{"region", &Filter{Region: "eu"}, []string{"b-v2"}},
+		{"tag", &Filter{Tags: map[string]string{"canary": "true"}}, []string{"a-v2"}},
+		{"no match", &Filter{Zone: "c"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instances, err := client.Discover(ctx, "ComputeS1", tt.filter)
+			var ids []string
+			for _, instance := range instances {
+				ids = append(ids, instance.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.ids) || (tt.ids == nil) != errors.Is(err, ErrServiceNotFound) {
+				t.Fatalf("expected %v, got %v %v", tt.ids, ids, err)
+			}
+		})
+	}
+}
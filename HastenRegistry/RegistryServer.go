@@ -15,28 +15,81 @@ const (
 	Registry OpType = iota
 	Discovery
 	HeartBeat
+	Deregister
+	Update
+	Watch
 )
 
+/*
+RegistryReq
+is what a client sends, each request is answered with exactly one response:
+
+	Registry      RegistryResp, the connection stays open for HeartBeat and Deregister
+	Discovery     DiscoveryResp, the connection is closed afterwards
+	HeartBeat     RegistryResp, renews the lease, also on a new connection which is closed afterwards
+	Update        RegistryResp, only on the connection of a Registry, replaces its instance
+	Deregister    RegistryResp, revokes the lease, also on a new connection, the connection is closed afterwards
+	Watch         WatchResp, followed by a WatchEvent for every change until the connection is closed
+*/
 type RegistryReq struct {
 	ServiceName string
 	OpType      OpType
+	Instance    *Instance     // the instance to register, required by Registry and Update
+	Filter      *Filter       // narrows a Discovery or a Watch, nil matches every instance
+	TTL         time.Duration // the lease a Registry asks for, 0 means DefaultTTL
+	LeaseID     string        // of the registration, required by a HeartBeat or a Deregister on a new connection
 }
 
 type RegistryResp struct {
-	Status string
-	Data   any
+	Code       StatusCode
+	Message    string
+	InstanceID string        // of the instance a Registry created
+	Instance   *Instance     // as stored by the registry after a Registry or an Update
+	LeaseID    string        // of the lease a Registry was granted
+	TTL        time.Duration // of the lease, a heartbeat has to arrive within it
+}
+
+type DiscoveryResp struct {
+	Code      StatusCode
+	Message   string
+	Instances []Instance // empty unless Code is StatusOK
 }
 
 type RegistryServer struct {
-	serviceIpMap cmap.ConcurrentMap[string, []string]
-	lock         sync.Mutex
+	serviceIpMap cmap.ConcurrentMap[string, []Instance]
+	lock         sync.Mutex // serializes the changes, their revisions and their events
+	revision     uint64     // counts every change of any service
+	watchers     map[string]map[*watcher]struct{}
+	leases       map[string]*lease // lease ID -> lease
+
+	clock        Clock
+	reapInterval time.Duration
+	done         chan struct{} // stops the reaper
+	closeOnce    sync.Once
 }
 
-func StartRegistryServer() *RegistryServer {
-	return &RegistryServer{
-		serviceIpMap: cmap.New[[]string](),
+// StartRegistryServer creates a registry and starts expiring its leases, Run or Serve make it reachable
+func StartRegistryServer(opts ...RegistryOption) *RegistryServer {
+	r := &RegistryServer{
+		serviceIpMap: cmap.New[[]Instance](),
 		lock:         sync.Mutex{},
+		watchers:     make(map[string]map[*watcher]struct{}),
+		leases:       make(map[string]*lease),
+		clock:        realClock{},
+		reapInterval: MinTTL / 2,
+		done:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(r)
 	}
+	go r.runReaper()
+	return r
+}
+
+// Close stops expiring leases, the listener passed to Serve is closed by its owner
+func (r *RegistryServer) Close() error {
+	r.closeOnce.Do(func() { close(r.done) })
+	return nil
 }
 
 func (r *RegistryServer) Run(registerIpAddr string) {
@@ -44,130 +97,249 @@ func (r *RegistryServer) Run(registerIpAddr string) {
 	if err != nil {
 		return
 	}
+	_ = r.Serve(listen)
+}
 
+// Serve handles the connections of listener until it fails
+func (r *RegistryServer) Serve(listener net.Listener) error {
 	for {
-		conn, err := listen.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
-			return
+			return err
 		}
 		go r.handleConnection(conn)
 	}
-
 }
 
 func (r *RegistryServer) handleConnection(conn net.Conn) {
+	decoder, encoder := json.NewDecoder(conn), json.NewEncoder(conn)
 
 	registryReq := RegistryReq{}
-	err := json.NewDecoder(conn).Decode(&registryReq)
+	err := decoder.Decode(&registryReq)
 	if err != nil {
+		_ = conn.Close()
 		return
 	}
 
+	// a HeartBeat and a Deregister name their lease instead of a service
 	serviceName := registryReq.ServiceName
+	if serviceName == "" && registryReq.OpType != HeartBeat && registryReq.OpType != Deregister {
+		_ = encoder.Encode(&RegistryResp{Code: StatusBadRequest, Message: "service name is empty"})
+		_ = conn.Close()
+		return
+	}
+
 	switch registryReq.OpType {
 	case Registry:
-		r.handleRegistry(serviceName, conn)
-		break
+		r.handleRegistry(serviceName, &registryReq, conn, decoder, encoder)
 
 	case Discovery:
-		r.handleDiscovery(serviceName, conn)
-		conn.Close()
-		break
+		r.handleDiscovery(serviceName, registryReq.Filter, encoder)
+		_ = conn.Close()
+
+	case Watch:
+		r.handleWatch(serviceName, registryReq.Filter, conn, encoder)
+
+	case HeartBeat:
+		code, message := r.renewLease(registryReq.LeaseID)
+		_ = encoder.Encode(&RegistryResp{Code: code, Message: message, LeaseID: registryReq.LeaseID})
+		_ = conn.Close()
+
+	case Deregister:
+		code, message := r.revokeLease(registryReq.LeaseID)
+		_ = encoder.Encode(&RegistryResp{Code: code, Message: message, LeaseID: registryReq.LeaseID})
+		_ = conn.Close()
+
 	default:
-		json.NewEncoder(conn).Encode("Not a valid operation")
-		conn.Close()
+		_ = encoder.Encode(&RegistryResp{
+			Code:    StatusBadRequest,
+			Message: fmt.Sprintf("operation %d is not valid on a new connection", registryReq.OpType),
+		})
+		_ = conn.Close()
 	}
 
 }
 
-func (r *RegistryServer) handleRegistry(serviceName string, conn net.Conn) {
+// handleRegistry records the advertised instance, it stays as long as its lease is renewed
+func (r *RegistryServer) handleRegistry(
+	serviceName string, registryReq *RegistryReq,
+	conn net.Conn, decoder *json.Decoder, encoder *json.Encoder) {
+
+	l, code, message := r.addInstance(serviceName, registryReq.Instance, registryReq.TTL, conn)
+	if code != StatusCreated {
+		_ = encoder.Encode(&RegistryResp{Code: code, Message: message})
+		_ = conn.Close()
+		return
+	}
+
+	err := encoder.Encode(&RegistryResp{
+		Code:       StatusCreated,
+		InstanceID: l.instanceID,
+		Instance:   registryReq.Instance, // normalized by addInstance
+		LeaseID:    l.id,
+		TTL:        l.ttl,
+	})
+	if err != nil {
+		r.revokeLease(l.id)
+		_ = conn.Close()
+		return
+	}
 
-	func() {
-		r.lock.Lock()
-		defer r.lock.Unlock()
+	go r.maintainHearBeat(l, conn, decoder, encoder)
 
-		//ipAddr := conn.RemoteAddr().String()
-		ipsSplice, ok := r.serviceIpMap.Get(serviceName)
-		if !ok {
-			ipsSplice = []string{}
-		}
-		ipsSplice = append(ipsSplice, conn.RemoteAddr().String())
-		r.serviceIpMap.Set(serviceName, ipsSplice)
+}
 
-		err := json.NewEncoder(conn).Encode(
-			&RegistryResp{
-				Status: "200",
-				Data:   nil,
-			},
-		)
+// addInstance registers instance together with a lease of about ttl
+func (r *RegistryServer) addInstance(
+	serviceName string, instance *Instance, ttl time.Duration, conn net.Conn) (*lease, StatusCode, string) {
 
-		if err != nil {
-			return
-		}
-	}()
+	if instance == nil {
+		return nil, StatusBadRequest, "no instance to register"
+	}
+	if ttl < 0 {
+		return nil, StatusBadRequest, fmt.Sprintf("negative ttl %v", ttl)
+	}
+	if err := normalizeInstance(instance, r.clock.Now()); err != nil {
+		return nil, StatusBadRequest, err.Error()
+	}
 
-	go r.maintainHearBeat(serviceName, conn)
+	r.lock.Lock()
+	defer r.lock.Unlock()
 
+	ipsSplice, ok := r.serviceIpMap.Get(serviceName)
+	if !ok {
+		ipsSplice = []Instance{}
+	}
+	for _, registered := range ipsSplice {
+		if registered.ID == instance.ID {
+			return nil, StatusConflict, "instance " + instance.ID + " is already registered"
+		}
+	}
+	ipsSplice = append(ipsSplice, *instance)
+	r.serviceIpMap.Set(serviceName, ipsSplice)
+	r.changed(serviceName, nil, instance)
+	return r.grantLease(serviceName, instance.ID, ttl, conn), StatusCreated, ""
 }
 
-func (r *RegistryServer) maintainHearBeat(serviceName string, conn net.Conn) {
-	for {
+// updateInstance replaces the registered instance instanceID, which keeps its ID and registration time
+func (r *RegistryServer) updateInstance(
+	serviceName string, instanceID string, instance *Instance) (*Instance, StatusCode, string) {
+
+	if instance == nil {
+		return nil, StatusBadRequest, "no instance to update"
+	}
 
-		conn.SetReadDeadline(time.Now().Add(5 * time.Second)) // 设置5秒后的截止时间
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	ipsSplice, _ := r.serviceIpMap.Get(serviceName)
+	for i := range ipsSplice {
+		if ipsSplice[i].ID != instanceID {
+			continue
+		}
+		updated := *instance
+		updated.ID = instanceID
+		if err := normalizeInstance(&updated, ipsSplice[i].RegisteredAt); err != nil {
+			return nil, StatusBadRequest, err.Error()
+		}
+
+		// the slice may be read by a Discovery right now
+		replaced := append([]Instance(nil), ipsSplice...)
+		old := replaced[i]
+		replaced[i] = updated
+		r.serviceIpMap.Set(serviceName, replaced)
+		r.changed(serviceName, &old, &updated)
+		return &updated, StatusOK, ""
+	}
+	return nil, StatusNotFound, "instance " + instanceID + " is not registered"
+}
 
+/*
+maintainHearBeat
+serves the requests on the registration connection of l. Losing the connection
+does not remove the instance, its lease expires unless a HeartBeat on another
+connection renews it.
+*/
+func (r *RegistryServer) maintainHearBeat(l *lease, conn net.Conn, decoder *json.Decoder, encoder *json.Encoder) {
+	defer conn.Close()
+
+	for {
 		registryReq := RegistryReq{}
-		err := json.NewDecoder(conn).Decode(&registryReq)
+		if err := decoder.Decode(&registryReq); err != nil {
+			return
+		}
 
-		if err != nil {
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				fmt.Println("读取超时")
-				//remove the service
-				r.lock.Lock()
-				defer r.lock.Unlock()
-				ipsSplice, ok := r.serviceIpMap.Get(serviceName)
-				if !ok {
-					break
-				}
-				for i, ip := range ipsSplice {
-					if ip == conn.RemoteAddr().String() {
-						ipsSplice = append(ipsSplice[:i], ipsSplice[i+1:]...)
-					}
-				}
-				break
-
-			} else {
-				fmt.Println("解码错误:", err)
-				break
-			}
+		resp := RegistryResp{InstanceID: l.instanceID, LeaseID: l.id, TTL: l.ttl}
+		switch registryReq.OpType {
+		case HeartBeat:
+			resp.Code, resp.Message = r.renewLease(l.id)
+		case Update:
+			resp.Instance, resp.Code, resp.Message = r.updateInstance(l.serviceName, l.instanceID, registryReq.Instance)
+		case Deregister:
+			resp.Code, resp.Message = r.revokeLease(l.id)
+			_ = encoder.Encode(&resp)
+			return
+		default:
+			resp.Code = StatusBadRequest
+			resp.Message = fmt.Sprintf("operation %d is not valid on a registration", registryReq.OpType)
+		}
+		if err := encoder.Encode(&resp); err != nil {
+			return
 		}
 	}
 
 }
 
-func (r *RegistryServer) handleDiscovery(serviceName string, conn net.Conn) {
-
-	ipsSlice, ok := r.serviceIpMap.Get(serviceName)
+// removeInstanceLocked is called with r.lock held
+func (r *RegistryServer) removeInstanceLocked(serviceName string, instanceID string) {
+	ipsSplice, ok := r.serviceIpMap.Get(serviceName)
 	if !ok {
-		err := json.NewEncoder(conn).Encode(
-			&RegistryResp{
-				Status: "404",
-				Data:   nil,
-			},
-		)
-		if err != nil {
-			return
+		return
+	}
+
+	var removed *Instance
+	remained := make([]Instance, 0, len(ipsSplice))
+	for i, instance := range ipsSplice {
+		if instance.ID != instanceID {
+			remained = append(remained, instance)
+		} else {
+			removed = &ipsSplice[i]
 		}
 	}
+	if removed == nil {
+		return
+	}
 
-	err := json.NewEncoder(conn).Encode(
-		&RegistryResp{
-			Status: "201",
-			Data:   ipsSlice,
-		},
-	)
+	if len(remained) == 0 {
+		r.serviceIpMap.Remove(serviceName)
+	} else {
+		r.serviceIpMap.Set(serviceName, remained)
+	}
+	r.changed(serviceName, removed, nil)
+}
 
-	if err != nil {
+func (r *RegistryServer) handleDiscovery(serviceName string, filter *Filter, encoder *json.Encoder) {
+
+	ipsSlice, _ := r.serviceIpMap.Get(serviceName)
+
+	instances := make([]Instance, 0, len(ipsSlice))
+	for i := range ipsSlice {
+		if filter.Matches(&ipsSlice[i]) {
+			instances = append(instances, ipsSlice[i])
+		}
+	}
+
+	if len(instances) == 0 {
+		_ = encoder.Encode(&DiscoveryResp{
+			Code:    StatusNotFound,
+			Message: "no instance of " + serviceName + " matches",
+		})
 		return
 	}
 
+	_ = encoder.Encode(&DiscoveryResp{
+		Code:      StatusOK,
+		Instances: instances,
+	})
+
 }
@@ -0,0 +1,63 @@
+package HastenRegistry
+
+import "fmt"
+
+// StatusCode is the outcome of a registry request, the values follow HTTP
+type StatusCode int
+
+const (
+	StatusOK         StatusCode = 200
+	StatusCreated    StatusCode = 201
+	StatusBadRequest StatusCode = 400
+	StatusNotFound   StatusCode = 404
+	StatusConflict   StatusCode = 409
+)
+
+var statusNames = map[StatusCode]string{
+	StatusOK:         "OK",
+	StatusCreated:    "Created",
+	StatusBadRequest: "BadRequest",
+	StatusNotFound:   "NotFound",
+	StatusConflict:   "Conflict",
+}
+
+func (c StatusCode) String() string {
+	if name, ok := statusNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("StatusCode(%d)", int(c))
+}
+
+// IsSuccess reports a 2xx code
+func (c StatusCode) IsSuccess() bool {
+	return c >= 200 && c < 300
+}
+
+/*
+RegistryError
+is a response of the registry whose code is no success, errors.Is matches
+the code only, e.g. errors.Is(err, ErrServiceNotFound).
+*/
+type RegistryError struct {
+	Code    StatusCode
+	Message string
+}
+
+var ErrServiceNotFound = &RegistryError{Code: StatusNotFound}
+
+func (e *RegistryError) Error() string {
+	return fmt.Sprintf("registry error: code = %s desc = %s", e.Code, e.Message)
+}
+
+func (e *RegistryError) Is(target error) bool {
+	t, ok := target.(*RegistryError)
+	return ok && t.Code == e.Code
+}
+
+// statusError returns nil for a successful code
+func statusError(code StatusCode, message string) error {
+	if code.IsSuccess() {
+		return nil
+	}
+	return &RegistryError{Code: code, Message: message}
+}
@@ -0,0 +1,209 @@
+package HastenRegistry
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+)
+
+type EventType int
+
+const (
+	EventAdd    EventType = iota // an instance appeared, or started to match the filter of the watch
+	EventRemove                  // an instance is gone, or stopped to match the filter of the watch
+	EventUpdate                  // an instance changed and still matches the filter of the watch
+)
+
+var eventNames = map[EventType]string{
+	EventAdd:    "Add",
+	EventRemove: "Remove",
+	EventUpdate: "Update",
+}
+
+func (e EventType) String() string {
+	return eventNames[e]
+}
+
+// WatchResp starts a watch with a snapshot of the matching instances at Revision
+type WatchResp struct {
+	Code      StatusCode
+	Message   string
+	Revision  uint64
+	Instances []Instance
+}
+
+// WatchEvent is one change after the snapshot, Revision grows with every event of a watch
+type WatchEvent struct {
+	Type     EventType
+	Revision uint64
+	Instance Instance
+}
+
+// watchBuffer is how many events a watcher may fall behind before it is dropped
+const watchBuffer = 64
+
+type watcher struct {
+	filter   *Filter
+	events   chan WatchEvent
+	overflow chan struct{} // closed once events was full
+	dropOnce sync.Once
+}
+
+// send never blocks the registry, a watcher too slow to keep up is dropped and has to watch again
+func (w *watcher) send(event WatchEvent) {
+	select {
+	case w.events <- event:
+	default:
+		w.dropOnce.Do(func() { close(w.overflow) })
+	}
+}
+
+/*
+changed
+bumps the revision and tells the watchers of serviceName that old became
+instance, old is nil for an added instance and instance nil for a removed one.
+The caller holds r.lock, which keeps the events in the order of their revisions.
+*/
+func (r *RegistryServer) changed(serviceName string, old *Instance, instance *Instance) {
+	r.revision++
+
+	for w := range r.watchers[serviceName] {
+		wasMatching := old != nil && w.filter.Matches(old)
+		isMatching := instance != nil && w.filter.Matches(instance)
+		switch {
+		case wasMatching && isMatching:
+			w.send(WatchEvent{Type: EventUpdate, Revision: r.revision, Instance: *instance})
+		case wasMatching:
+			w.send(WatchEvent{Type: EventRemove, Revision: r.revision, Instance: *old})
+		case isMatching:
+			w.send(WatchEvent{Type: EventAdd, Revision: r.revision, Instance: *instance})
+		}
+	}
+}
+
+// handleWatch sends the snapshot and then the events of serviceName until the client hangs up
+func (r *RegistryServer) handleWatch(serviceName string, filter *Filter, conn net.Conn, encoder *json.Encoder) {
+	defer conn.Close()
+
+	w := &watcher{
+		filter:   filter,
+		events:   make(chan WatchEvent, watchBuffer),
+		overflow: make(chan struct{}),
+	}
+	snapshot := r.addWatcher(serviceName, w)
+	defer r.removeWatcher(serviceName, w)
+
+	if err := encoder.Encode(snapshot); err != nil {
+		return
+	}
+
+	// nothing is expected from the client, the read only ends when it hangs up
+	hungUp := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+		close(hungUp)
+	}()
+
+	for {
+		select {
+		case event := <-w.events:
+			if err := encoder.Encode(&event); err != nil {
+				return
+			}
+		case <-w.overflow:
+			return
+		case <-hungUp:
+			return
+		}
+	}
+}
+
+// addWatcher takes the snapshot and subscribes w at once, so w misses no change after the snapshot
+func (r *RegistryServer) addWatcher(serviceName string, w *watcher) *WatchResp {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.watchers[serviceName] == nil {
+		r.watchers[serviceName] = make(map[*watcher]struct{})
+	}
+	r.watchers[serviceName][w] = struct{}{}
+
+	ipsSlice, _ := r.serviceIpMap.Get(serviceName)
+	snapshot := &WatchResp{Code: StatusOK, Revision: r.revision, Instances: []Instance{}}
+	for i := range ipsSlice {
+		if w.filter.Matches(&ipsSlice[i]) {
+			snapshot.Instances = append(snapshot.Instances, ipsSlice[i])
+		}
+	}
+	return snapshot
+}
+
+func (r *RegistryServer) removeWatcher(serviceName string, w *watcher) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.watchers[serviceName], w)
+	if len(r.watchers[serviceName]) == 0 {
+		delete(r.watchers, serviceName)
+	}
+}
+
+/*--------------------------*/
+
+/*
+Watcher
+is the client side of a watch: the snapshot it started with, followed by the
+events returned by Next.
+*/
+type Watcher struct {
+	ServiceName string
+	Revision    uint64     // of the snapshot, then of the last event returned by Next
+	Instances   []Instance // the snapshot
+
+	conn    net.Conn
+	decoder *json.Decoder
+}
+
+/*
+Watch
+subscribes to the changes of the instances of serviceName which match filter,
+ctx only bounds setting the watch up. Watching a service without instances is
+fine, the snapshot is empty then.
+*/
+func (c *RegistryClient) Watch(ctx context.Context, serviceName string, filter *Filter) (*Watcher, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Watcher{ServiceName: serviceName, conn: conn, decoder: json.NewDecoder(conn)}
+	var resp WatchResp
+	err = roundTrip(ctx, conn, json.NewEncoder(conn), w.decoder,
+		&RegistryReq{ServiceName: serviceName, OpType: Watch, Filter: filter}, &resp)
+	if err == nil {
+		err = statusError(resp.Code, resp.Message)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	w.Revision, w.Instances = resp.Revision, resp.Instances
+	return w, nil
+}
+
+// Next blocks until the next event, an error means the watch is over and has to be started again
+func (w *Watcher) Next() (WatchEvent, error) {
+	var event WatchEvent
+	if err := w.decoder.Decode(&event); err != nil {
+		return WatchEvent{}, err
+	}
+	w.Revision = event.Revision
+	return event, nil
+}
+
+func (w *Watcher) Close() error {
+	return w.conn.Close()
+}
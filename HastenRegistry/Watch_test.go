@@ -0,0 +1,72 @@
+package HastenRegistry
+
+import (
+	"context"
+	"testing"
+)
+
+func TestWatch(t *testing.T) {
+	client := NewRegistryClient(startTestRegistry(t))
+	ctx := context.Background()
+	endpoints := []Endpoint{{"tcp", "10.0.0.7:12312"}}
+
+	first, err := client.Register(ctx, "ComputeS1", Instance{ID: "first", Endpoints: endpoints, Zone: "a"})
+	if err != nil {
+		t.Fatalf("register first: %v", err)
+	}
+	defer first.Close()
+
+	watcher, err := client.Watch(ctx, "ComputeS1", &Filter{Zone: "a"})
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	defer watcher.Close()
+	if len(watcher.Instances) != 1 || watcher.Instances[0].ID != "first" {
+		t.Fatalf("unexpected snapshot: %+v", watcher.Instances)
+	}
+
+	second, err := client.Register(ctx, "ComputeS1", Instance{ID: "second", Endpoints: endpoints, Zone: "a"})
+	if err != nil {
+		t.Fatalf("register second: %v", err)
+	}
+	// not in zone a, the watch never hears of it
+	other, err := client.Register(ctx, "ComputeS1", Instance{ID: "other", Endpoints: endpoints, Zone: "b"})
+	if err != nil {
+		t.Fatalf("register other: %v", err)
+	}
+	defer other.Close()
+	if err = first.Update(ctx, Instance{Endpoints: endpoints, Zone: "a", Weight: 5}); err != nil {
+		t.Fatalf("update first: %v", err)
+	}
+	if err = first.Update(ctx, Instance{Endpoints: endpoints, Zone: "b"}); err != nil {
+		t.Fatalf("move first: %v", err)
+	}
+	// reg.Instance is what the registry stored, not what was sent
+	if got := first.Instance; got.ID != "first" || got.Weight != DefaultWeight || got.RegisteredAt.IsZero() {
+		t.Fatalf("unexpected instance after update: %+v", got)
+	}
+	if err = second.Deregister(ctx); err != nil {
+		t.Fatalf("deregister second: %v", err)
+	}
+
+	want := []struct {
+		eventType EventType
+		id        string
+	}{
+		{EventAdd, "second"},
+		{EventUpdate, "first"},
+		{EventRemove, "first"}, // it left zone a
+		{EventRemove, "second"},
+	}
+	revision := watcher.Revision
+	for _, w := range want {
+		event, err := watcher.Next()
+		if err != nil {
+			t.Fatalf("next: %v", err)
+		}
+		if event.Type != w.eventType || event.Instance.ID != w.id || event.Revision <= revision {
+			t.Fatalf("expected %v %s after revision %d, got %+v", w.eventType, w.id, revision, event)
+		}
+		revision = event.Revision
+	}
+}
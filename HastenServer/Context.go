@@ -0,0 +1,88 @@
+package HastenServer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"oh_my_rpc_v2/HastenProtocol"
+	"time"
+)
+
+// RequestInfo describes the request a handler is serving
+type RequestInfo struct {
+	StructMethod string   // e.g. "ComputeS1.Add"
+	Seq          uint64   // the seq of the request on its connection
+	Peer         net.Addr // the remote address of the caller
+}
+
+type requestInfoKey struct{}
+
+/*
+RequestInfoFromContext
+returns the RequestInfo of the request served with ctx, handlers get such a ctx
+as their first parameter. ok is false for any other context.
+*/
+func RequestInfoFromContext(ctx context.Context) (info RequestInfo, ok bool) {
+	info, ok = ctx.Value(requestInfoKey{}).(RequestInfo)
+	return info, ok
+}
+
+// PeerFromContext returns the remote address of the caller of the request served with ctx
+func PeerFromContext(ctx context.Context) (net.Addr, bool) {
+	info, ok := RequestInfoFromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	return info.Peer, true
+}
+
+/*
+newRequestContext
+derives the context of one request from parent, it carries the RequestInfo and
+the metadata of the request, and ends at the deadline sent by the caller or when a cancel frame for the same seq
+arrives.
+*/
+func (sc *serverConn) newRequestContext(parent context.Context, header *HastenProtocol.Header) context.Context {
+	ctx := context.WithValue(parent, requestInfoKey{}, RequestInfo{
+		StructMethod: header.StructMethod,
+		Seq:          header.Seq,
+		Peer:         sc.conn.RemoteAddr(),
+	})
+	ctx = withRequestMetadata(ctx, header)
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	if header.Deadline != 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithDeadline(ctx, time.Unix(0, header.Deadline))
+		cancelCause := cancel
+		cancel = func(cause error) {
+			cancelCause(cause)
+			stop()
+		}
+	}
+
+	sc.cancelLock.Lock()
+	defer sc.cancelLock.Unlock()
+	sc.cancels[header.Seq] = cancel
+	return ctx
+}
+
+// errAbandoned is the cause of the context of a request whose caller sent a cancel frame
+var errAbandoned = errors.New("rpc server: the caller canceled the request")
+
+/*
+cancelRequest
+ends the context of the request seq with cause, called with errAbandoned for a
+cancel frame and with nil once the request has been answered. An unknown seq
+has already been answered.
+*/
+func (sc *serverConn) cancelRequest(seq uint64, cause error) {
+	sc.cancelLock.Lock()
+	cancel, ok := sc.cancels[seq]
+	delete(sc.cancels, seq)
+	sc.cancelLock.Unlock()
+
+	if ok {
+		cancel(cause)
+	}
+}
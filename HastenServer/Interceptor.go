@@ -0,0 +1,98 @@
+package HastenServer
+
+import (
+	"context"
+	"oh_my_rpc_v2/HastenProtocol"
+	"reflect"
+)
+
+// UnaryServerInfo describes the method a request is about to call
+type UnaryServerInfo struct {
+	StructMethod string // e.g. "ComputeS1.Add"
+	ServiceName  string
+	MethodName   string
+	ArgType      reflect.Type
+	ReplyType    reflect.Type
+}
+
+// UnaryHandler calls the next interceptor, the last one in the chain calls the method itself
+type UnaryHandler func(ctx context.Context, argv any) (reply any, err error)
+
+/*
+UnaryServerInterceptor
+wraps the call of a method. It sees the request header, the decoded argv and the
+method, it may change the header (the response reuses it), short-circuit by
+returning without calling handler, or inspect and replace the result of handler.
+header.Metadata is the metadata of the request, the metadata of the response is
+set with SetResponseMetadata.
+*/
+type UnaryServerInterceptor func(
+	ctx context.Context, header *HastenProtocol.Header, argv any,
+	info *UnaryServerInfo, handler UnaryHandler) (reply any, err error)
+
+/*
+WithUnaryInterceptors
+appends interceptors to the chain of the server. The first interceptor is the
+outermost one: it runs first before the method and last after it.
+*/
+func WithUnaryInterceptors(interceptors ...UnaryServerInterceptor) ServerOption {
+	return func(server *RpcServer) {
+		server.interceptors = append(server.interceptors, interceptors...)
+	}
+}
+
+func chainUnaryInterceptors(
+	interceptors []UnaryServerInterceptor, header *HastenProtocol.Header,
+	info *UnaryServerInfo, final UnaryHandler) UnaryHandler {
+
+	handler := final
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, argv any) (any, error) {
+			return interceptor(ctx, header, argv, info, next)
+		}
+	}
+	return handler
+}
+
+/*
+invoke
+runs req through the interceptor chain of the server and returns the reply to
+send. A panic of the method reaches the interceptors as a *PanicError, a panic
+of an interceptor is recovered here as well.
+*/
+func (server *RpcServer) invoke(req *request) (reply any, err error) {
+	defer func() {
+		if panicErr := server.recoverPanic(req.header.StructMethod, recover()); panicErr != nil {
+			reply, err = nil, panicErr
+		}
+	}()
+
+	final := func(ctx context.Context, argv any) (reply any, err error) {
+		defer func() {
+			if panicErr := server.recoverPanic(req.header.StructMethod, recover()); panicErr != nil {
+				reply, err = nil, panicErr
+			}
+		}()
+
+		argValue := reflect.ValueOf(argv)
+		if !argValue.IsValid() {
+			argValue = reflect.Zero(req.method.argType)
+		}
+		err = req.service.call(ctx, req.method, argValue, req.replyv)
+		return req.replyv.Interface(), err
+	}
+
+	if len(server.interceptors) == 0 {
+		return final(req.ctx, req.argv.Interface())
+	}
+
+	info := &UnaryServerInfo{
+		StructMethod: req.header.StructMethod,
+		ServiceName:  req.service.serviceName,
+		MethodName:   req.method.method.Name,
+		ArgType:      req.method.argType,
+		ReplyType:    req.method.replyType,
+	}
+	return chainUnaryInterceptors(server.interceptors, req.header, info, final)(req.ctx, req.argv.Interface())
+}
@@ -0,0 +1,75 @@
+package HastenServer
+
+import (
+	"context"
+	"errors"
+	"oh_my_rpc_v2/HastenProtocol"
+	"sync"
+)
+
+var ErrNoRequestContext = errors.New("rpc server: context does not belong to a request")
+
+// requestMetadata is the metadata of one request, the response metadata is filled in by its handler
+type requestMetadata struct {
+	incoming HastenProtocol.Metadata
+
+	lock     sync.Mutex
+	response HastenProtocol.Metadata
+}
+
+type requestMetadataKey struct{}
+
+/*
+MetadataFromContext
+returns the metadata the caller sent with the request served with ctx. It is
+the Metadata of the request header, so what interceptors add to it is visible
+to the handler. It must not be changed by handlers.
+*/
+func MetadataFromContext(ctx context.Context) (HastenProtocol.Metadata, bool) {
+	md, ok := ctx.Value(requestMetadataKey{}).(*requestMetadata)
+	if !ok {
+		return nil, false
+	}
+	return md.incoming, true
+}
+
+/*
+SetResponseMetadata
+adds key and value to the metadata of the response to the request served with
+ctx, it is sent with errors as well. Calls after the response has been sent
+have no effect.
+*/
+func SetResponseMetadata(ctx context.Context, key string, value string) error {
+	md, ok := ctx.Value(requestMetadataKey{}).(*requestMetadata)
+	if !ok {
+		return ErrNoRequestContext
+	}
+
+	md.lock.Lock()
+	defer md.lock.Unlock()
+	if md.response == nil {
+		md.response = make(HastenProtocol.Metadata)
+	}
+	md.response[key] = value
+	return nil
+}
+
+// withRequestMetadata stores the metadata of header in ctx, header.Metadata is never nil afterwards
+func withRequestMetadata(ctx context.Context, header *HastenProtocol.Header) context.Context {
+	if header.Metadata == nil {
+		header.Metadata = make(HastenProtocol.Metadata)
+	}
+	return context.WithValue(ctx, requestMetadataKey{}, &requestMetadata{incoming: header.Metadata})
+}
+
+// responseMetadata returns a copy of what the handler of the request served with ctx set so far
+func responseMetadata(ctx context.Context) HastenProtocol.Metadata {
+	md, ok := ctx.Value(requestMetadataKey{}).(*requestMetadata)
+	if !ok {
+		return nil
+	}
+
+	md.lock.Lock()
+	defer md.lock.Unlock()
+	return md.response.Clone()
+}
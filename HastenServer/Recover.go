@@ -0,0 +1,43 @@
+package HastenServer
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+)
+
+// PanicError is what a panic while handling a request turns into, the caller gets it as HastenProtocol.CodeInternal
+type PanicError struct {
+	StructMethod string
+	Value        any    // the value passed to panic
+	Stack        []byte // the stack of the panicking goroutine
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("rpc server: panic in %s: %v", e.StructMethod, e.Value)
+}
+
+/*
+recoverPanic
+must be called from a deferred function with the result of recover(). A non-nil
+value is logged with its stack, counted and returned as a *PanicError.
+*/
+func (server *RpcServer) recoverPanic(structMethod string, value any) *PanicError {
+	if value == nil {
+		return nil
+	}
+
+	panicErr := &PanicError{
+		StructMethod: structMethod,
+		Value:        value,
+		Stack:        debug.Stack(),
+	}
+	server.panics.Add(1)
+	log.Printf("%v\n%s", panicErr, panicErr.Stack)
+	return panicErr
+}
+
+// PanicCount returns how many panics were recovered since the server was created
+func (server *RpcServer) PanicCount() uint64 {
+	return server.panics.Load()
+}
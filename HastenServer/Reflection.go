@@ -0,0 +1,59 @@
+package HastenServer
+
+import (
+	"oh_my_rpc_v2/HastenProtocol"
+	"sort"
+	"strings"
+)
+
+/*
+reflectionService
+is registered by NewRpcServer as HastenProtocol.ReflectionServiceName, it tells
+callers without compiled stubs which services and methods the server exposes.
+*/
+type reflectionService struct {
+	server *RpcServer
+}
+
+// ListServices describes every service whose name starts with prefix, an empty prefix lists all of them
+func (r *reflectionService) ListServices(prefix string, reply *HastenProtocol.ListServicesReply) error {
+	reply.Services = []HastenProtocol.ServiceDescriptor{}
+	for name, s := range r.server.serviceMap.Items() {
+		if strings.HasPrefix(name, prefix) {
+			reply.Services = append(reply.Services, s.describe())
+		}
+	}
+	sort.Slice(reply.Services, func(i, j int) bool {
+		return reply.Services[i].Name < reply.Services[j].Name
+	})
+	return nil
+}
+
+// DescribeService describes the service serviceName, an unknown service is answered with CodeNotFound
+func (r *reflectionService) DescribeService(serviceName string, reply *HastenProtocol.ServiceDescriptor) error {
+	s, ok := r.server.serviceMap.Get(serviceName)
+	if !ok {
+		return Errorf(HastenProtocol.CodeNotFound, "rpc server: service %s not found", serviceName)
+	}
+	*reply = s.describe()
+	return nil
+}
+
+func (s *service) describe() HastenProtocol.ServiceDescriptor {
+	descriptor := HastenProtocol.ServiceDescriptor{
+		Name:    s.serviceName,
+		Methods: make([]HastenProtocol.MethodDescriptor, 0, len(s.methodMap)),
+	}
+	for name, m := range s.methodMap {
+		descriptor.Methods = append(descriptor.Methods, HastenProtocol.MethodDescriptor{
+			Name:         name,
+			StructMethod: s.serviceName + "." + name,
+			Args:         HastenProtocol.SchemaOf(m.argType),
+			Reply:        HastenProtocol.SchemaOf(m.replyType),
+		})
+	}
+	sort.Slice(descriptor.Methods, func(i, j int) bool {
+		return descriptor.Methods[i].Name < descriptor.Methods[j].Name
+	})
+	return descriptor
+}
@@ -0,0 +1,109 @@
+package HastenServer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"oh_my_rpc_v2/HastenRegistry"
+	"sync"
+	"time"
+)
+
+// HeartbeatRetryInterval is the wait after the first failed heartbeat, it doubles up to the heartbeat interval
+var HeartbeatRetryInterval = 100 * time.Millisecond
+
+/*
+registration
+keeps the instance of AcceptWithRegistry in the registry. The lease outlives
+the registration connection, so a broken connection is bridged with Renew on a
+new one, and a lost lease, e.g. after a restart of the registry, with a new
+registration of the same instance.
+*/
+type registration struct {
+	client *HastenRegistry.RegistryClient
+
+	lock    sync.Mutex
+	current *HastenRegistry.Registration // replaced once its lease is lost
+
+	ctx     context.Context // canceled by deregister, ends the heartbeats
+	cancel  context.CancelFunc
+	stopped chan struct{} // closed once maintainHeartbeat returned
+}
+
+func newRegistration(client *HastenRegistry.RegistryClient, current *HastenRegistry.Registration) *registration {
+	reg := &registration{
+		client:  client,
+		current: current,
+		stopped: make(chan struct{}),
+	}
+	reg.ctx, reg.cancel = context.WithCancel(context.Background())
+	return reg
+}
+
+func (reg *registration) registration() *HastenRegistry.Registration {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+	return reg.current
+}
+
+func (server *RpcServer) maintainHeartbeat(reg *registration) {
+	defer close(reg.stopped)
+
+	var retry time.Duration
+	for {
+		interval := reg.registration().HeartbeatInterval()
+		wait := interval
+		if retry > 0 {
+			wait = retry
+		}
+
+		select {
+		case <-reg.ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		if err := reg.renew(reg.ctx); err != nil {
+			log.Println("rpc server: heartbeat error:", err)
+			retry = min(max(2*retry, HeartbeatRetryInterval), interval)
+			continue
+		}
+		retry = 0
+	}
+
+}
+
+/*
+renew
+heartbeats on the registration connection, falls back to Renew on a new
+connection once it broke and registers the instance again once the registry no
+longer knows its lease.
+*/
+func (reg *registration) renew(ctx context.Context) error {
+	current := reg.registration()
+
+	err := current.Heartbeat(ctx)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, HastenRegistry.ErrServiceNotFound) {
+		// the connection is of no use anymore, the lease may well be
+		_ = current.Close()
+		err = reg.client.Renew(ctx, current.LeaseID)
+	}
+	if !errors.Is(err, HastenRegistry.ErrServiceNotFound) {
+		return err
+	}
+
+	fresh, err := reg.client.RegisterWithTTL(ctx, current.ServiceName, current.Instance, current.TTL)
+	if err != nil {
+		return err
+	}
+	log.Printf("rpc server: lease %s was lost, registered again with lease %s\n", current.LeaseID, fresh.LeaseID)
+	_ = current.Close()
+
+	reg.lock.Lock()
+	reg.current = fresh
+	reg.lock.Unlock()
+	return nil
+}
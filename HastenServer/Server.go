@@ -1,8 +1,9 @@
 package HastenServer
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
+	"fmt"
 	cmap "github.com/orcaman/concurrent-map/v2"
 	"io"
 	"log"
@@ -12,114 +13,277 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 )
 
 type RpcServer struct {
 	serviceMap cmap.ConcurrentMap[string, *service]
+	workers    chan struct{} // a token per request being handled
+
+	interceptors []UnaryServerInterceptor // see WithUnaryInterceptors
+	panics       atomic.Uint64            // see PanicCount
+
+	mu               sync.Mutex
+	listeners        map[net.Listener]struct{}
+	conns            map[*serverConn]struct{}
+	registration     *registration           // nil unless AcceptWithRegistry was used
+	registryTTL      time.Duration           // see WithRegistryTTL
+	handshakeTimeout time.Duration           // see WithHandshakeTimeout
+	instance         HastenRegistry.Instance // see WithInstance
+	shuttingDown     atomic.Bool
+
+	ctx    context.Context // the parent of every request context, canceled by Close
+	cancel context.CancelFunc
 }
 
-func NewRpcServer() *RpcServer {
-	return &RpcServer{
-		serviceMap: cmap.New[*service](),
+// serverConn is one client connection, wg counts its requests in flight
+type serverConn struct {
+	conn  net.Conn
+	codec HastenProtocol.RpcCodec // nil until the handshake is done
+	wg    *sync.WaitGroup
+
+	cancelLock sync.Mutex
+	cancels    map[uint64]context.CancelCauseFunc // seq -> cancel of the request in flight
+}
+
+var ErrServerClosed = errors.New("rpc server: server closed")
+
+func NewRpcServer(opts ...ServerOption) *RpcServer {
+	server := &RpcServer{
+		serviceMap:       cmap.New[*service](),
+		workers:          make(chan struct{}, DefaultMaxWorkers),
+		handshakeTimeout: DefaultHandshakeTimeout,
+		listeners:        make(map[net.Listener]struct{}),
+		conns:            make(map[*serverConn]struct{}),
+	}
+	server.ctx, server.cancel = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(server)
 	}
+	_ = server.register(HastenProtocol.ReflectionServiceName, &reflectionService{server: server})
+	return server
 }
 
-func (server *RpcServer) Accept(listener net.Listener) {
+// maxAcceptDelay caps the backoff of Accept after temporary errors, as in net/http
+const maxAcceptDelay = time.Second
+
+/*
+Accept
+serves every connection of listener in its own goroutine until the listener
+fails or the server is shut down, in which case ErrServerClosed is returned.
+Temporary errors such as running out of file descriptors are retried with a
+backoff of up to maxAcceptDelay.
+*/
+func (server *RpcServer) Accept(listener net.Listener) error {
+	if !server.trackListener(listener) {
+		_ = listener.Close()
+		return ErrServerClosed
+	}
+	defer server.untrackListener(listener)
+
+	var delay time.Duration
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			log.Println("Error accepting connection:" + conn.RemoteAddr().String())
+			if server.shuttingDown.Load() {
+				return ErrServerClosed
+			}
+			if isTemporary(err) {
+				delay = min(max(2*delay, 5*time.Millisecond), maxAcceptDelay)
+				log.Printf("rpc server: Error accepting connection: %v; retrying in %v\n", err, delay)
+				time.Sleep(delay)
+				continue
+			}
+			log.Println("rpc server: Error accepting connection:", err)
+			return err
 		}
+		delay = 0
+
+		go server.handleConnection(conn)
+	}
+}
 
-		//go server.handleConnection(conn)
-		//TODO: for debug
-		server.handleConnection(conn)
+// isTemporary reports an error worth retrying the Accept after, e.g. EMFILE or ENFILE
+func isTemporary(err error) bool {
+	var temporary interface{ Temporary() bool }
+	if errors.As(err, &temporary) && temporary.Temporary() {
+		return true
 	}
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
 }
 
-func (server *RpcServer) AcceptWithRegistry(listener net.Listener, registryIpAddr string, serviceName string) {
+/*
+AcceptWithRegistry
+registers serviceName in the registry and then serves listener like Accept.
+The instance is described by WithInstance, unless it lists its endpoints
+clients are told to dial the address of listener, which must then not listen
+on an unspecified host such as 0.0.0.0.
+*/
+func (server *RpcServer) AcceptWithRegistry(listener net.Listener, registryIpAddr string, serviceName string) error {
 	/*
 		1. register me into the registry center
 		2. Accept()
 		3. go heartBeat()
 	*/
-	connReg, err := net.Dial("tcp", registryIpAddr)
+	instance := server.instance
+	if len(instance.Endpoints) == 0 {
+		instance.Endpoints = []HastenRegistry.Endpoint{HastenRegistry.EndpointOf(listener.Addr())}
+	}
+	registryClient := HastenRegistry.NewRegistryClient(registryIpAddr)
+	registryReg, err := registryClient.RegisterWithTTL(context.Background(), serviceName, instance, server.registryTTL)
 	if err != nil {
-		return
+		return err
 	}
 
-	registryReq := HastenRegistry.RegistryReq{
-		ServiceName: serviceName,
-		OpType:      HastenRegistry.Registry,
-	}
-	json.NewEncoder(connReg).Encode(&registryReq)
+	reg := newRegistration(registryClient, registryReg)
+	server.mu.Lock()
+	server.registration = reg
+	server.mu.Unlock()
 
-	go server.maintainHeartbeat(connReg, serviceName)
+	go server.maintainHeartbeat(reg)
 
-	server.Accept(listener)
+	return server.Accept(listener)
 
 }
 
-func (server *RpcServer) maintainHeartbeat(connReg net.Conn, serviceName string) {
-	for {
-		time.Sleep(3 * time.Second)
-
-		registryReq := HastenRegistry.RegistryReq{
-			ServiceName: serviceName,
-			OpType:      HastenRegistry.HeartBeat,
-		}
-		json.NewEncoder(connReg).Encode(&registryReq)
+func (server *RpcServer) handleConnection(conn net.Conn) {
+	sc := &serverConn{
+		conn:    conn,
+		wg:      new(sync.WaitGroup),
+		cancels: make(map[uint64]context.CancelCauseFunc),
 	}
+	if !server.trackConn(sc) {
+		_ = conn.Close()
+		return
+	}
+	defer server.untrackConn(sc)
 
-}
-
-func (server *RpcServer) handleConnection(conn net.Conn) {
 	/*pre check*/
+	// a client which never completes the handshake must not hold the connection forever
+	_ = conn.SetDeadline(time.Now().Add(server.handshakeTimeout))
 	opt := new(HastenProtocol.Option)
-	err := server.validateOption(conn, opt)
+	ack, err := server.validateOption(conn, opt)
 	if err != nil {
+		log.Println("rpc Server: reject connection:", err)
+		server.rejectOption(conn, err)
 		return
 	}
 
-	codec, err := HastenProtocol.CodecFactory(conn, opt.CodecType)
+	codec, err := HastenProtocol.CodecFactory(conn, ack.CodecType)
 	if err != nil {
+		log.Println("rpc Server: reject connection:", err)
+		server.rejectOption(conn, &HastenProtocol.HandshakeError{
+			Code:    HastenProtocol.HandshakeUnknownCodec,
+			Message: err.Error(),
+		})
 		return
 	}
 
-	server.handleRpcRequest(codec)
+	if limiter, ok := codec.(HastenProtocol.FrameLimiter); ok {
+		limiter.SetMaxFrameSize(ack.Capabilities.MaxFrameSize)
+	}
+
+	// the client holds back its requests until it has read the ack
+	err = HastenProtocol.WriteOptionAck(conn, ack)
+	if err != nil {
+		log.Println("rpc Server: Error encoding option ack:" + err.Error())
+		_ = codec.Close()
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	server.mu.Lock()
+	sc.codec = codec
+	server.mu.Unlock()
+
+	server.handleRpcRequest(sc)
 }
 
 // aim to every connection
-func (server *RpcServer) validateOption(conn net.Conn, opt *HastenProtocol.Option) error {
-	err := json.NewDecoder(conn).Decode(opt)
+func (server *RpcServer) validateOption(conn net.Conn, opt *HastenProtocol.Option) (*HastenProtocol.OptionAck, error) {
+	err := HastenProtocol.ReadOption(conn, opt)
 	//log.Println("rpc Server: Received option: ", opt)
 	if err != nil {
 		log.Println("rpc Server: Error decoding option:" + err.Error())
-		return err
+		return nil, &HastenProtocol.HandshakeError{Code: HastenProtocol.HandshakeMalformed, Message: err.Error()}
 	}
 
 	if opt.MagicNumber != HastenProtocol.DefaultMagicNumber {
 		log.Println("rpc Server: Invalid magic number")
-		return err
+		return nil, &HastenProtocol.HandshakeError{
+			Code:    HastenProtocol.HandshakeBadMagic,
+			Message: fmt.Sprintf("got %#x", opt.MagicNumber),
+		}
+	}
+
+	if !HastenProtocol.IsCodecRegistered(opt.CodecType) {
+		return nil, &HastenProtocol.HandshakeError{
+			Code:    HastenProtocol.HandshakeUnknownCodec,
+			Message: string(opt.CodecType),
+		}
+	}
+
+	version, err := HastenProtocol.NegotiateVersion(opt.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HastenProtocol.OptionAck{
+		Accepted:     true,
+		CodecType:    opt.CodecType,
+		Version:      version,
+		Capabilities: server.capabilities(),
+	}, nil
+}
+
+// capabilities are announced to every client in its OptionAck
+func (server *RpcServer) capabilities() HastenProtocol.Capabilities {
+	return HastenProtocol.Capabilities{
+		Compression:  false,
+		Streaming:    false,
+		MaxFrameSize: HastenProtocol.DefaultMaxFrameSize,
+	}
+}
+
+// rejectOption tells the client why its Option was refused and drops the connection
+func (server *RpcServer) rejectOption(conn net.Conn, reason error) {
+	defer conn.Close()
+
+	ack := &HastenProtocol.OptionAck{
+		Accepted: false,
+		Code:     HastenProtocol.HandshakeMalformed,
+		Error:    reason.Error(),
+	}
+	var handshakeErr *HastenProtocol.HandshakeError
+	if errors.As(reason, &handshakeErr) {
+		ack.Code, ack.Error = handshakeErr.Code, handshakeErr.Message
+	}
+
+	err := HastenProtocol.WriteOptionAck(conn, ack)
+	if err != nil {
+		log.Println("rpc Server: Error encoding option ack:" + err.Error())
 	}
-	return nil
 }
 
 type request struct {
 	header  *HastenProtocol.Header
 	argv    reflect.Value
 	replyv  reflect.Value
-	method  *reflect.Method
+	method  *methodType
 	service *service
+	ctx     context.Context // carries the deadline of the caller and its cancellation
 }
 
-var invalidReqBody = struct{}{}
+// error responses carry an empty body
+var invalidReqBody any
 
-func (server *RpcServer) handleRpcRequest(codec HastenProtocol.RpcCodec) {
+func (server *RpcServer) handleRpcRequest(sc *serverConn) {
 	// A lock for sending response in one specific connection
 	//sendingLock := new(sync.Mutex)
-	wg := new(sync.WaitGroup)
+	codec, wg := sc.codec, sc.wg
 
 	defer func(codec HastenProtocol.RpcCodec) {
 		err := codec.Close()
@@ -129,53 +293,92 @@ func (server *RpcServer) handleRpcRequest(codec HastenProtocol.RpcCodec) {
 	}(codec)
 
 	for {
-		req, err := server.getRequest(codec)
-		if err != nil { //header decode went wrong
-			if req == nil { //no req, faulted data received? Not, actually, it's something like EOF
+		req, err := server.getRequest(sc)
+		if err != nil {
+			if req == nil { // the connection is broken or closed, e.g. EOF
 				break
 			}
-			req.header.Error = err.Error()
+			req.header.Status = statusOf(err)
+			req.header.Metadata = nil
+			server.sendRpcResponse(codec, req.header, invalidReqBody)
+			continue
+		}
+
+		// the client has been told to go away, whatever it still sends is refused
+		if !server.beginRequest(wg) {
+			req.header.Status = HastenProtocol.NewStatus(HastenProtocol.CodeUnavailable, ErrServerClosed.Error())
+			req.header.Metadata = nil
 			server.sendRpcResponse(codec, req.header, invalidReqBody)
 			continue
 		}
-		wg.Add(1)
 
-		//go server.doHandleRpcRequest(codec, req, wg)
+		req.ctx = sc.newRequestContext(server.ctx, req.header)
 
-		//TODO: for debug
-		server.doHandleRpcRequest(codec, req, wg)
+		// the request waits for a worker in its own goroutine, so cancel frames are still read
+		go server.doHandleRpcRequest(sc, req)
 	}
 
 	wg.Wait()
 }
 
 /*
-	type request struct {
-		header  *HastenProtocol.Header
-		argv    reflect.Value
-		replyv  reflect.Value
-		method  *reflect.Method
-		service *service
-	}
+getRequest
+reads frames until one of them is a request or has to be answered:
+
+  - a cancel frame aborts the request in flight with the same seq, reading goes on
+  - a frame whose header cannot be decoded has been skipped, without a seq it
+    cannot be answered, reading goes on
+  - a frame that is too large, too much metadata, an unknown method or a body
+    that cannot be decoded return the request together with the error, the
+    caller answers its seq
+  - every other error comes from the connection, req is nil and the connection
+    has to be closed
+
+the body of every frame has been consumed once getRequest returns.
 */
-func (server *RpcServer) getRequest(codec HastenProtocol.RpcCodec) (*request, error) {
-	var header HastenProtocol.Header
-	err := codec.ReadHeader(&header)
-	if err != nil {
-		if err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
-			log.Println("rpc server: get header error:", err)
+func (server *RpcServer) getRequest(sc *serverConn) (*request, error) {
+	codec := sc.codec
+
+	for {
+		header := new(HastenProtocol.Header)
+		err := codec.ReadHeader(header)
+		switch {
+		case err == nil:
+		case errors.Is(err, HastenProtocol.ErrMalformedFrame):
+			log.Println("rpc server: skip frame:", err)
+			continue
+		case errors.Is(err, HastenProtocol.ErrMetadataTooLarge), errors.Is(err, HastenProtocol.ErrFrameTooLarge):
+			_ = codec.ReadBody(nil)
+			return &request{header: header}, err
+		default:
+			if err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+				log.Println("rpc server: get header error:", err)
+			}
+			return nil, err
+		}
+
+		// a cancel frame is no request, it aborts the request in flight with the same seq
+		if header.Flags&HastenProtocol.FlagCancel != 0 {
+			_ = codec.ReadBody(nil)
+			sc.cancelRequest(header.Seq, errAbandoned)
+			continue
 		}
+
+		return server.readRequest(codec, header)
 	}
+}
 
-	/*handle body. The client body is the argv*/
+// readRequest finds the method of header and decodes the body of the frame into its argv
+func (server *RpcServer) readRequest(codec HastenProtocol.RpcCodec, header *HastenProtocol.Header) (*request, error) {
 	aStruct, method, err := server.findStruct(header.StructMethod)
 	if err != nil {
-		return nil, err
+		_ = codec.ReadBody(nil)
+		return &request{header: header}, err
 	}
 
 	//parts of the protocol
-	argv := newArgv(method.Type.In(1))
-	replyv := newReplyv(method.Type.In(2))
+	argv := newArgv(method.argType)
+	replyv := newReplyv(method.replyType)
 
 	// the ReadBody receive only the pointer of the argv
 	argvAny := argv.Interface()
@@ -187,10 +390,12 @@ func (server *RpcServer) getRequest(codec HastenProtocol.RpcCodec) (*request, er
 	err = codec.ReadBody(argvAny)
 	if err != nil {
 		log.Println("rpc server: get body error:", err)
+		return &request{header: header}, HastenProtocol.Errorf(HastenProtocol.CodeInvalidArgument,
+			"rpc server: decode args of %s: %v", header.StructMethod, err)
 	}
 
 	return &request{
-		header:  &header,
+		header:  header,
 		argv:    argv,
 		replyv:  replyv,
 		method:  method,
@@ -198,33 +403,148 @@ func (server *RpcServer) getRequest(codec HastenProtocol.RpcCodec) (*request, er
 	}, nil
 }
 
-func (server *RpcServer) findStruct(serviceMethod string) (*service, *reflect.Method, error) {
+func (server *RpcServer) findStruct(serviceMethod string) (*service, *methodType, error) {
 	dotIndex := strings.LastIndex(serviceMethod, ".")
 	if dotIndex < 0 {
-		return nil, nil, errors.New("rpc server: invalid anyObj method format")
+		return nil, nil, HastenProtocol.Errorf(HastenProtocol.CodeInvalidArgument,
+			"rpc server: invalid anyObj method format: %q", serviceMethod)
 	}
 
 	serviceName, methodName := serviceMethod[:dotIndex], serviceMethod[dotIndex+1:]
 
 	aStruct, isExist := server.serviceMap.Get(serviceName)
 	if !isExist {
-		return nil, nil, errors.New("rpc server:  aStruct:" + serviceName + " not found")
+		return nil, nil, HastenProtocol.NewStatus(HastenProtocol.CodeNotFound,
+			"rpc server:  aStruct:"+serviceName+" not found")
 	}
 
 	method := aStruct.methodMap[methodName]
 	if method == nil {
-		return nil, nil, errors.New("rpc server: method:" + methodName + " not found in aStruct:" + serviceName)
+		return nil, nil, HastenProtocol.NewStatus(HastenProtocol.CodeNotFound,
+			"rpc server: method:"+methodName+" not found in aStruct:"+serviceName)
 	}
 	return aStruct, method, nil
 
 }
+
+var (
+	ErrServiceRegistered    = errors.New("rpc server: service already registered")
+	ErrServiceNotRegistered = errors.New("rpc server: service not registered")
+	ErrBuiltinService       = errors.New("rpc server: built-in service")
+)
+
+// RegisterService registers the methods of structObj under the name of its type
 func (server *RpcServer) RegisterService(structObj any) error {
-	serviceObjPtr := newService(structObj)
+	return server.RegisterName("", structObj)
+}
+
+/*
+RegisterName
+registers the methods of structObj under serviceName instead of the name of its
+type, e.g. one instance of the same type per tenant. An empty serviceName falls
+back to the name of the type. serviceName must not contain a dot, the dotted
+names belong to the built-in services, nor whitespace.
+*/
+func (server *RpcServer) RegisterName(serviceName string, structObj any) error {
+	if err := checkServiceName(serviceName); err != nil {
+		return err
+	}
+	return server.register(serviceName, structObj)
+}
+
+/*
+checkServiceName
+refuses whitespace, which no type name contains either, and dots: the dotted
+names, e.g. HastenProtocol.ReflectionServiceName, are reserved for the built-in
+services, so a service of the user can never take their place.
+*/
+func checkServiceName(serviceName string) error {
+	if isBuiltinService(serviceName) || strings.ContainsFunc(serviceName, unicode.IsSpace) {
+		return fmt.Errorf("rpc Server: %q is not a valid service name", serviceName)
+	}
+	return nil
+}
+
+// isBuiltinService reports whether serviceName is one of the dotted names reserved for the built-in services
+func isBuiltinService(serviceName string) bool {
+	return strings.Contains(serviceName, ".")
+}
+
+// register is RegisterName without checking serviceName, only the built-in services are registered this way
+func (server *RpcServer) register(serviceName string, structObj any) error {
+	serviceObjPtr, err := newService(structObj, serviceName)
+	if err != nil {
+		return err
+	}
 
 	ok := server.serviceMap.SetIfAbsent(serviceObjPtr.serviceName, serviceObjPtr)
 	if !ok {
-		return errors.New("rpc server: serviceObjPtr already registered")
+		return fmt.Errorf("%w: %s", ErrServiceRegistered, serviceObjPtr.serviceName)
+	}
+	return nil
+}
+
+/*
+Unregister
+removes the service serviceName, struct or functions. Requests already running
+on it complete, new requests are answered with HastenProtocol.CodeNotFound.
+The built-in services cannot be removed.
+*/
+func (server *RpcServer) Unregister(serviceName string) error {
+	if isBuiltinService(serviceName) {
+		return fmt.Errorf("%w: %s", ErrBuiltinService, serviceName)
 	}
+	if _, ok := server.serviceMap.Pop(serviceName); !ok {
+		return fmt.Errorf("%w: %s", ErrServiceNotRegistered, serviceName)
+	}
+	log.Printf("rpc Server: unregister %s\n", serviceName)
+	return nil
+}
+
+/*
+RegisterFunc
+exposes fn as structMethod, e.g. "Math.Add", next to the struct services. The
+type parameters are checked by the compiler, A and R must still be exported or
+builtin types. Functions registered under the same service name form one
+service, which must not be a registered struct.
+*/
+func RegisterFunc[A, R any](s *RpcServer, structMethod string, fn RpcFunc[A, R]) error {
+	return s.registerFunc(structMethod, fn)
+}
+
+// registerFunc is RegisterFunc for any fn which looks like a method without its receiver
+func (server *RpcServer) registerFunc(structMethod string, fn any) error {
+	dotIndex := strings.LastIndex(structMethod, ".")
+	if dotIndex <= 0 || dotIndex == len(structMethod)-1 {
+		return fmt.Errorf("rpc server: invalid function name %q, want Service.Method", structMethod)
+	}
+	serviceName, methodName := structMethod[:dotIndex], structMethod[dotIndex+1:]
+	if err := checkServiceName(serviceName); err != nil {
+		return err
+	}
+
+	mType, err := newFuncMethod(methodName, fn)
+	if err != nil {
+		return fmt.Errorf("rpc server: register %s: %w", structMethod, err)
+	}
+
+	server.serviceMap.Upsert(serviceName, nil, func(exist bool, current *service, _ *service) *service {
+		switch {
+		case !exist:
+			current = &service{serviceName: serviceName}
+		case current.serviceValue.IsValid():
+			err = fmt.Errorf("rpc server: register %s: %s is a struct service", structMethod, serviceName)
+			return current
+		case current.methodMap[methodName] != nil:
+			err = fmt.Errorf("rpc server: register %s: already registered", structMethod)
+			return current
+		}
+		return current.withFunc(methodName, mType)
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("rpc Server: register %s\n", structMethod)
 	return nil
 }
 
@@ -238,25 +558,79 @@ func (server *RpcServer) sendRpcResponse(
 	}
 
 	err := codec.Write(protocol)
-	if err != nil {
-		log.Println("rpc server: write response error:", err)
+	if err == nil {
+		return
+	}
+	log.Println("rpc server: write response error:", err)
+
+	// the caller still waits for this seq, tell it why the response never came
+	if !isStreamIntact(err) || header.Flags&HastenProtocol.FlagGoAway != 0 {
 		return
 	}
+	fallback := &HastenProtocol.Header{
+		StructMethod: header.StructMethod,
+		Seq:          header.Seq,
+		Status:       HastenProtocol.Errorf(HastenProtocol.CodeInternal, "rpc server: cannot send the response: %v", err),
+	}
+	if err = codec.Write(&HastenProtocol.RpcProtocol{Header: fallback}); err != nil {
+		log.Println("rpc server: write fallback response error:", err)
+	}
+}
+
+// isStreamIntact reports a write error which happened before anything went on the wire
+func isStreamIntact(err error) bool {
+	return errors.Is(err, HastenProtocol.ErrMetadataTooLarge) ||
+		errors.Is(err, HastenProtocol.ErrFrameTooLarge) ||
+		errors.Is(err, HastenProtocol.ErrUnencodable)
 }
 
-func (server *RpcServer) doHandleRpcRequest(codec HastenProtocol.RpcCodec, req *request, wg *sync.WaitGroup) {
-	defer wg.Done()
+/*
+doHandleRpcRequest
+runs in its own goroutine, the codec's write lock keeps the responses from
+interleaving. A request the caller canceled with a cancel frame is never
+answered, whether that happened before or after the handler ran: the caller
+has stopped waiting. Every other request is answered, one whose deadline
+passed with CodeDeadlineExceeded.
+*/
+func (server *RpcServer) doHandleRpcRequest(sc *serverConn, req *request) {
+	codec := sc.codec
+	defer sc.wg.Done()
+	defer sc.cancelRequest(req.header.Seq, nil)
+
+	select {
+	case server.workers <- struct{}{}:
+		defer func() { <-server.workers }()
+	case <-req.ctx.Done():
+		// the caller gave up before a worker was free
+	}
+
+	if context.Cause(req.ctx) == errAbandoned {
+		return
+	}
+	if err := req.ctx.Err(); err != nil {
+		req.header.Status = statusOf(err)
+		req.header.Metadata = nil
+		server.sendRpcResponse(codec, req.header, invalidReqBody)
+		return
+	}
+
+	reply, err := server.invoke(req)
+
+	if context.Cause(req.ctx) == errAbandoned {
+		return
+	}
 
-	err := req.service.call(req.method, req.argv, req.replyv)
+	// the response carries the metadata set by the handler, not the one of the request
+	req.header.Metadata = responseMetadata(req.ctx)
 
 	if err != nil {
-		req.header.Error = err.Error()
+		req.header.Status = statusOf(err)
 		server.sendRpcResponse(codec, req.header, invalidReqBody)
 		return
 	}
 
 	//server send the replyv as the body
-	server.sendRpcResponse(codec, req.header, req.replyv.Interface())
+	server.sendRpcResponse(codec, req.header, reply)
 }
 
 //type request struct {
@@ -0,0 +1,56 @@
+package HastenServer
+
+import (
+	"oh_my_rpc_v2/HastenRegistry"
+	"time"
+)
+
+// ServerOption configures an RpcServer in NewRpcServer
+type ServerOption func(server *RpcServer)
+
+// DefaultHandshakeTimeout bounds the Option handshake of a connection unless WithHandshakeTimeout says otherwise
+const DefaultHandshakeTimeout = 10 * time.Second
+
+// DefaultMaxWorkers is the number of requests a server handles at the same time unless WithMaxWorkers says otherwise
+const DefaultMaxWorkers = 256
+
+/*
+WithMaxWorkers
+bounds the requests executing concurrently over all connections of the server,
+the requests beyond it wait for a worker until their context is done.
+*/
+func WithMaxWorkers(maxWorkers int) ServerOption {
+	return func(server *RpcServer) {
+		if maxWorkers > 0 {
+			server.workers = make(chan struct{}, maxWorkers)
+		}
+	}
+}
+
+/*
+WithInstance
+describes the instance AcceptWithRegistry registers: its ID, advertised
+endpoints, weight, version, zone, region and tags. Clients filter on them when
+they discover the service.
+*/
+func WithInstance(instance HastenRegistry.Instance) ServerOption {
+	return func(server *RpcServer) {
+		server.instance = instance
+	}
+}
+
+// WithRegistryTTL asks the registry for a lease of ttl in AcceptWithRegistry, by default HastenRegistry.DefaultTTL
+func WithRegistryTTL(ttl time.Duration) ServerOption {
+	return func(server *RpcServer) {
+		server.registryTTL = ttl
+	}
+}
+
+// WithHandshakeTimeout closes connections which have not completed the Option handshake within timeout
+func WithHandshakeTimeout(timeout time.Duration) ServerOption {
+	return func(server *RpcServer) {
+		if timeout > 0 {
+			server.handshakeTimeout = timeout
+		}
+	}
+}
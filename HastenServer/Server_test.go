@@ -1,10 +1,22 @@
 package HastenServer
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"oh_my_rpc_v2/Common"
+	"oh_my_rpc_v2/HastenClient"
+	"oh_my_rpc_v2/HastenProtocol"
+	"oh_my_rpc_v2/HastenRegistry"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 )
 
 type Student struct {
@@ -28,3 +40,959 @@ func TestServer(t *testing.T) {
 	server.Accept(listen)
 
 }
+
+type Sleeper struct{}
+
+// Sleep holds the request for ms milliseconds and echoes ms back
+func (s *Sleeper) Sleep(ms int, reply *int) error {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	*reply = ms
+	return nil
+}
+
+// sleeperCtxErrs receives the context error every Sleeper.Wait returns with
+var sleeperCtxErrs = make(chan error, 16)
+
+// Wait is Sleep which gives up once ctx is done
+func (s *Sleeper) Wait(ctx context.Context, ms int, reply *int) error {
+	select {
+	case <-time.After(time.Duration(ms) * time.Millisecond):
+		*reply = ms
+		return nil
+	case <-ctx.Done():
+		sleeperCtxErrs <- ctx.Err()
+		return ctx.Err()
+	}
+}
+
+func startTestServer(t *testing.T, opts ...ServerOption) (*RpcServer, string) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewRpcServer(opts...)
+	if err = server.RegisterService(new(Sleeper)); err != nil {
+		t.Fatal(err)
+	}
+	go server.Accept(listen)
+	t.Cleanup(func() { _ = server.Close() })
+	return server, listen.Addr().String()
+}
+
+func dialTestClient(t *testing.T, addr string) *HastenClient.Client {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	option := HastenProtocol.DefaultOption
+	option.CodecType = HastenProtocol.JsonType
+	client, err := HastenClient.NewClient(conn, &option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestServeClientsConcurrently(t *testing.T) {
+	_, addr := startTestServer(t)
+
+	const clients, sleepMs = 8, 200
+	start := time.Now()
+	wg := new(sync.WaitGroup)
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var reply int
+			if err := dialTestClient(t, addr).Call(context.Background(), "Sleeper.Sleep", sleepMs, &reply); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed > clients*sleepMs*time.Millisecond/2 {
+		t.Fatalf("%d clients took %v, they were not served in parallel", clients, elapsed)
+	}
+}
+
+func TestServeInterleavedSeqs(t *testing.T) {
+	_, addr := startTestServer(t)
+	client := dialTestClient(t, addr)
+
+	// the slow request goes first but must be answered last
+	var slowReply, fastReply int
+	slow := client.Go(context.Background(), "Sleeper.Sleep", 300, &slowReply, nil)
+	fast := client.Go(context.Background(), "Sleeper.Sleep", 10, &fastReply, nil)
+
+	select {
+	case call := <-fast.Done:
+		if call.Error != nil || fastReply != 10 {
+			t.Fatalf("unexpected fast response: %d %v", fastReply, call.Error)
+		}
+	case <-slow.Done:
+		t.Fatal("the slow request blocked the fast one")
+	}
+
+	if call := <-slow.Done; call.Error != nil || slowReply != 300 {
+		t.Fatalf("unexpected slow response: %d %v", slowReply, call.Error)
+	}
+}
+
+func TestMaxWorkers(t *testing.T) {
+	_, addr := startTestServer(t, WithMaxWorkers(1))
+	client := dialTestClient(t, addr)
+
+	start := time.Now()
+	var firstReply, secondReply int
+	first := client.Go(context.Background(), "Sleeper.Sleep", 100, &firstReply, nil)
+	second := client.Go(context.Background(), "Sleeper.Sleep", 100, &secondReply, nil)
+	<-first.Done
+	<-second.Done
+
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("a single worker served two requests in %v", elapsed)
+	}
+}
+
+func TestShutdownDrainsRequests(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewRpcServer()
+	_ = server.RegisterService(new(Sleeper))
+	accepted := make(chan error, 1)
+	go func() { accepted <- server.Accept(listen) }()
+
+	client := dialTestClient(t, listen.Addr().String())
+	var reply int
+	call := client.Go(context.Background(), "Sleeper.Sleep", 200, &reply, nil)
+	time.Sleep(50 * time.Millisecond) // let the request reach the handler
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	if call = <-call.Done; call.Error != nil || reply != 200 {
+		t.Fatalf("the request in flight was not completed: %d %v", reply, call.Error)
+	}
+	if err := <-accepted; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("expected ErrServerClosed from Accept, got %v", err)
+	}
+	if err := client.Call(context.Background(), "Sleeper.Sleep", 1, &reply); !errors.Is(err, HastenClient.ErrGoAway) {
+		t.Fatalf("expected ErrGoAway after the go-away frame, got %v", err)
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	server, addr := startTestServer(t)
+
+	client := dialTestClient(t, addr)
+	var reply int
+	client.Go(context.Background(), "Sleeper.Sleep", 500, &reply, nil)
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestCallDeadline(t *testing.T) {
+	_, addr := startTestServer(t)
+	client := dialTestClient(t, addr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	var reply int
+	if err := client.Call(ctx, "Sleeper.Wait", 2000, &reply); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("the deadline was not enforced by the client: %v", elapsed)
+	}
+
+	select {
+	case err := <-sleeperCtxErrs:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected the handler to see context.DeadlineExceeded, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the deadline did not reach the handler")
+	}
+
+	// the connection is still usable after the abandoned call
+	if err := client.Call(context.Background(), "Sleeper.Wait", 1, &reply); err != nil || reply != 1 {
+		t.Fatalf("call after the deadline: %d %v", reply, err)
+	}
+}
+
+func TestCallCancel(t *testing.T) {
+	_, addr := startTestServer(t)
+	client := dialTestClient(t, addr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var reply int
+	call := client.Go(ctx, "Sleeper.Wait", 2000, &reply, nil)
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	if call = <-call.Done; !errors.Is(call.Error, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", call.Error)
+	}
+
+	select {
+	case err := <-sleeperCtxErrs:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected the handler to see context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the cancel frame did not reach the handler")
+	}
+}
+
+// requestsInFlight counts the request contexts the server has not ended yet
+func requestsInFlight(server *RpcServer) int {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	n := 0
+	for sc := range server.conns {
+		sc.cancelLock.Lock()
+		n += len(sc.cancels)
+		sc.cancelLock.Unlock()
+	}
+	return n
+}
+
+func TestCancelWhileWorkersBusy(t *testing.T) {
+	server, addr := startTestServer(t, WithMaxWorkers(1))
+	client := dialTestClient(t, addr)
+
+	var busyReply, abandonedReply int
+	busy := client.Go(context.Background(), "Sleeper.Sleep", 1000, &busyReply, nil)
+	time.Sleep(50 * time.Millisecond)
+
+	// waits for the only worker, its cancel frame has to be read meanwhile
+	ctx, cancel := context.WithCancel(context.Background())
+	abandoned := client.Go(ctx, "Sleeper.Sleep", 1000, &abandonedReply, nil)
+	time.Sleep(50 * time.Millisecond)
+	if n := requestsInFlight(server); n != 2 {
+		t.Fatalf("expected 2 requests in flight, got %d", n)
+	}
+	cancel()
+	if call := <-abandoned.Done; !errors.Is(call.Error, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", call.Error)
+	}
+
+	// the busy request still holds the worker
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for requestsInFlight(server) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("the cancel frame was not read while the workers were busy")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if call := <-busy.Done; call.Error != nil || busyReply != 1000 {
+		t.Fatalf("busy call: %d %v", busyReply, call.Error)
+	}
+}
+
+func TestUnaryInterceptors(t *testing.T) {
+	var order []string
+	var orderLock sync.Mutex
+	record := func(name string) UnaryServerInterceptor {
+		return func(ctx context.Context, header *HastenProtocol.Header, argv any,
+			info *UnaryServerInfo, handler UnaryHandler) (any, error) {
+			orderLock.Lock()
+			order = append(order, name+">"+info.StructMethod)
+			orderLock.Unlock()
+
+			reply, err := handler(ctx, argv)
+
+			orderLock.Lock()
+			order = append(order, "<"+name)
+			orderLock.Unlock()
+			return reply, err
+		}
+	}
+	deny := func(ctx context.Context, header *HastenProtocol.Header, argv any,
+		info *UnaryServerInfo, handler UnaryHandler) (any, error) {
+		if argv.(int) < 0 {
+			return nil, errors.New("negative sleep")
+		}
+		return handler(ctx, argv)
+	}
+
+	_, addr := startTestServer(t, WithUnaryInterceptors(record("outer"), record("inner")), WithUnaryInterceptors(deny))
+	client := dialTestClient(t, addr)
+
+	var reply int
+	if err := client.Call(context.Background(), "Sleeper.Sleep", 1, &reply); err != nil || reply != 1 {
+		t.Fatalf("Sleeper.Sleep: %d %v", reply, err)
+	}
+	want := []string{"outer>Sleeper.Sleep", "inner>Sleeper.Sleep", "<inner", "<outer"}
+	if fmt.Sprint(order) != fmt.Sprint(want) {
+		t.Fatalf("interceptor order %v, want %v", order, want)
+	}
+
+	err := client.Call(context.Background(), "Sleeper.Sleep", -1, &reply)
+	var status *HastenProtocol.Status
+	if !errors.As(err, &status) || status.Message != "negative sleep" {
+		t.Fatalf("expected the interceptor to short-circuit, got %v", err)
+	}
+}
+
+type Faulty struct {
+	counters map[string]int // never made, every write panics
+}
+
+func (f *Faulty) Count(key string, reply *int) error {
+	f.counters[key]++
+	*reply = f.counters[key]
+	return nil
+}
+
+func TestRecoverHandlerPanic(t *testing.T) {
+	var panicked []bool // what the interceptor saw for each call
+	var seenLock sync.Mutex
+	observe := func(ctx context.Context, header *HastenProtocol.Header, argv any,
+		info *UnaryServerInfo, handler UnaryHandler) (any, error) {
+		reply, err := handler(ctx, argv)
+		var panicErr *PanicError
+		seenLock.Lock()
+		panicked = append(panicked, errors.As(err, &panicErr))
+		seenLock.Unlock()
+		return reply, err
+	}
+	server, addr := startTestServer(t, WithUnaryInterceptors(observe))
+	if err := server.RegisterService(new(Faulty)); err != nil {
+		t.Fatal(err)
+	}
+	client := dialTestClient(t, addr)
+
+	var reply int
+	err := client.Call(context.Background(), "Faulty.Count", "requests", &reply)
+	if HastenProtocol.CodeOf(err) != HastenProtocol.CodeInternal || !strings.Contains(err.Error(), "panic in Faulty.Count") {
+		t.Fatalf("expected the panic to be reported, got %v", err)
+	}
+	if server.PanicCount() != 1 {
+		t.Fatalf("PanicCount = %d, want 1", server.PanicCount())
+	}
+
+	// neither the connection nor the server went down
+	if err = client.Call(context.Background(), "Sleeper.Sleep", 1, &reply); err != nil || reply != 1 {
+		t.Fatalf("call after the panic: %d %v", reply, err)
+	}
+	seenLock.Lock()
+	defer seenLock.Unlock()
+	if fmt.Sprint(panicked) != "[true false]" {
+		t.Fatalf("the interceptor saw panics %v, want [true false]", panicked)
+	}
+}
+
+type Divider struct{}
+
+type RetryInfo struct {
+	AfterMs int
+}
+
+func (d *Divider) Div(operands TwoOperands, quotient *int) error {
+	if operands.B == 0 {
+		status, _ := HastenProtocol.NewStatus(HastenProtocol.CodeInvalidArgument, "division by zero").
+			WithDetails(RetryInfo{AfterMs: 10})
+		return status
+	}
+	*quotient = operands.A / operands.B
+	return nil
+}
+
+func TestStatusCodes(t *testing.T) {
+	server, addr := startTestServer(t)
+	_ = server.RegisterService(new(Divider))
+	client := dialTestClient(t, addr)
+
+	var quotient int
+	err := client.Call(context.Background(), "Divider.Div", TwoOperands{A: 1, B: 0}, &quotient)
+	if !errors.Is(err, &HastenProtocol.Status{Code: HastenProtocol.CodeInvalidArgument}) {
+		t.Fatalf("expected CodeInvalidArgument, got %v", err)
+	}
+	var status *HastenProtocol.Status
+	var retry RetryInfo
+	if !errors.As(err, &status) || !status.DecodeDetail(&retry) || retry.AfterMs != 10 {
+		t.Fatalf("expected a RetryInfo detail, got %+v", status)
+	}
+
+	err = client.Call(context.Background(), "Divider.Div", TwoOperands{A: 4, B: 2}, &quotient)
+	if code := HastenProtocol.CodeOf(err); code != HastenProtocol.CodeOK || quotient != 2 {
+		t.Fatalf("Divider.Div: %d %v", quotient, err)
+	}
+}
+
+type Tenant struct{}
+
+// Who answers with the tenant the caller sent and tells which server answered
+func (tn *Tenant) Who(ctx context.Context, name string, reply *string) error {
+	md, _ := MetadataFromContext(ctx)
+	*reply = name + "@" + md.Get("tenant") + " via " + md.Get("caller")
+	return SetResponseMetadata(ctx, "served-by", "tenant-server")
+}
+
+// Flood sets response metadata of size bytes, too much of it can never be sent
+func (tn *Tenant) Flood(ctx context.Context, size int, reply *string) error {
+	*reply = "flooded"
+	return SetResponseMetadata(ctx, "flood", strings.Repeat("x", size))
+}
+
+func TestMetadata(t *testing.T) {
+	tagCaller := func(ctx context.Context, header *HastenProtocol.Header, argv any,
+		info *UnaryServerInfo, handler UnaryHandler) (any, error) {
+		header.Metadata["caller"] = "interceptor"
+		return handler(ctx, argv)
+	}
+	server, addr := startTestServer(t, WithUnaryInterceptors(tagCaller))
+	_ = server.RegisterService(new(Tenant))
+	client := dialTestClient(t, addr)
+
+	var reply string
+	var responseMD HastenProtocol.Metadata
+	err := client.Call(context.Background(), "Tenant.Who", "alice", &reply,
+		HastenClient.WithMetadata(HastenProtocol.Metadata{"tenant": "acme"}),
+		HastenClient.WithResponseMetadata(&responseMD))
+	if err != nil || reply != "alice@acme via interceptor" {
+		t.Fatalf("Tenant.Who: %q %v", reply, err)
+	}
+	if responseMD.Get("served-by") != "tenant-server" || responseMD.Get("tenant") != "" {
+		t.Fatalf("unexpected response metadata: %v", responseMD)
+	}
+
+	tooLarge := HastenProtocol.Metadata{"tenant": strings.Repeat("x", HastenProtocol.MaxMetadataSize)}
+	err = client.Call(context.Background(), "Tenant.Who", "bob", &reply, HastenClient.WithMetadata(tooLarge))
+	if HastenProtocol.CodeOf(err) != HastenProtocol.CodeResourceExhausted {
+		t.Fatalf("expected CodeResourceExhausted, got %v", err)
+	}
+
+	// the response cannot be written, the caller gets a fallback instead of waiting forever
+	call := client.Go(context.Background(), "Tenant.Flood", HastenProtocol.MaxMetadataSize, &reply, nil)
+	select {
+	case call = <-call.Done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no response to a reply with too much metadata")
+	}
+	if HastenProtocol.CodeOf(call.Error) != HastenProtocol.CodeInternal {
+		t.Fatalf("expected CodeInternal, got %v", call.Error)
+	}
+	if err = client.Call(context.Background(), "Tenant.Flood", 16, &reply); err != nil || reply != "flooded" {
+		t.Fatalf("the connection is unusable after the fallback: %q %v", reply, err)
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	_, addr := startTestServer(t, WithHandshakeTimeout(50*time.Millisecond))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// the client never sends its Option, the server gives up on it
+	start := time.Now()
+	if _, err = conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the connection to be closed")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("the connection was held for %v", elapsed)
+	}
+
+	// a completed handshake is not bound by the timeout
+	client := dialTestClient(t, addr)
+	time.Sleep(100 * time.Millisecond)
+	var reply int
+	if err = client.Call(context.Background(), "Sleeper.Sleep", 1, &reply); err != nil || reply != 1 {
+		t.Fatalf("call after the handshake timeout: %d %v", reply, err)
+	}
+}
+
+// dialRawConn does the handshake by hand, the test writes frames to conn and reads the responses with the returned codec
+func dialRawConn(t *testing.T, addr string) (net.Conn, HastenProtocol.RpcCodec) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	option := HastenProtocol.DefaultOption
+	option.CodecType = HastenProtocol.JsonType
+	if err = HastenProtocol.WriteOption(conn, &option); err != nil {
+		t.Fatalf("write option: %v", err)
+	}
+	var ack HastenProtocol.OptionAck
+	if err = HastenProtocol.ReadOptionAck(conn, &ack); err != nil || !ack.Accepted {
+		t.Fatalf("read option ack: %v %+v", err, ack)
+	}
+	return conn, HastenProtocol.NewJsonCodec(conn)
+}
+
+func requestFrame(seq uint64, structMethod string, body string) *HastenProtocol.Frame {
+	header, _ := json.Marshal(&HastenProtocol.Header{StructMethod: structMethod, Seq: seq})
+	return &HastenProtocol.Frame{Header: header, Body: []byte(body)}
+}
+
+func TestGetRequest(t *testing.T) {
+	badMagic := requestFrame(1, "Sleeper.Sleep", "0")
+
+	tests := []struct {
+		name     string
+		frames   []*HastenProtocol.Frame
+		answered bool                     // seq 1 gets a response with code
+		code     HastenProtocol.ErrorCode // of the response to seq 1
+		closed   bool                     // the connection is dropped
+	}{
+		{name: "valid request", frames: []*HastenProtocol.Frame{requestFrame(1, "Sleeper.Sleep", "0")},
+			answered: true, code: HastenProtocol.CodeOK},
+		{name: "unknown service", frames: []*HastenProtocol.Frame{requestFrame(1, "Nobody.Sleep", "0")},
+			answered: true, code: HastenProtocol.CodeNotFound},
+		{name: "unknown method", frames: []*HastenProtocol.Frame{requestFrame(1, "Sleeper.Snore", "0")},
+			answered: true, code: HastenProtocol.CodeNotFound},
+		{name: "invalid method format", frames: []*HastenProtocol.Frame{requestFrame(1, "SleeperSleep", "0")},
+			answered: true, code: HastenProtocol.CodeInvalidArgument},
+		{name: "malformed body", frames: []*HastenProtocol.Frame{requestFrame(1, "Sleeper.Sleep", `"zero"`)},
+			answered: true, code: HastenProtocol.CodeInvalidArgument},
+		{name: "expired deadline", frames: []*HastenProtocol.Frame{
+			{Header: []byte(`{"StructMethod":"Sleeper.Sleep","Seq":1,"Deadline":1}`), Body: []byte("0")}},
+			answered: true, code: HastenProtocol.CodeDeadlineExceeded},
+		{name: "malformed header", frames: []*HastenProtocol.Frame{{Header: []byte("{seq"), Body: []byte("0")}}},
+		{name: "body too large", frames: []*HastenProtocol.Frame{
+			requestFrame(1, "Sleeper.Sleep", strings.Repeat("0", HastenProtocol.DefaultMaxFrameSize))},
+			answered: true, code: HastenProtocol.CodeResourceExhausted},
+		{name: "header too large", frames: []*HastenProtocol.Frame{
+			{Header: make([]byte, HastenProtocol.DefaultMaxFrameSize+1), Body: []byte("0")}}},
+		{name: "cancel of an unknown seq", frames: []*HastenProtocol.Frame{
+			{Flags: HastenProtocol.FlagCancel, Header: []byte(`{"Seq":1}`)}}},
+		{name: "bad magic", frames: []*HastenProtocol.Frame{badMagic}, closed: true},
+	}
+
+	_, addr := startTestServer(t)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn, codec := dialRawConn(t, addr)
+
+			for _, frame := range test.frames {
+				data := new(bytes.Buffer)
+				_ = HastenProtocol.WriteFrame(data, frame, 0)
+				if frame == badMagic {
+					data.Bytes()[0] = 0
+				}
+				if _, err := conn.Write(data.Bytes()); err != nil {
+					t.Fatalf("write frame: %v", err)
+				}
+			}
+			// the connection must still work after whatever came before
+			_ = HastenProtocol.WriteFrame(conn, requestFrame(2, "Sleeper.Sleep", "0"), 0)
+
+			var header HastenProtocol.Header
+			if test.closed {
+				if err := codec.ReadHeader(&header); err == nil {
+					t.Fatalf("expected the connection to be closed, got %+v", header)
+				}
+				return
+			}
+
+			// the requests run concurrently, so their responses come in any order
+			responses := make(map[uint64]HastenProtocol.Header)
+			expected := 1
+			if test.answered {
+				expected = 2
+			}
+			for len(responses) < expected {
+				header = HastenProtocol.Header{}
+				if err := codec.ReadHeader(&header); err != nil {
+					t.Fatalf("read response: %v", err)
+				}
+				_ = codec.ReadBody(nil)
+				responses[header.Seq] = header
+			}
+
+			if response, ok := responses[2]; !ok || response.Status != nil {
+				t.Fatalf("expected seq 2 to succeed: %+v", responses)
+			}
+			if !test.answered {
+				return
+			}
+			response, ok := responses[1]
+			if !ok {
+				t.Fatalf("expected a response to seq 1: %+v", responses)
+			}
+			code := HastenProtocol.CodeOK
+			if response.Status != nil {
+				code = response.Status.Code
+			}
+			if code != test.code {
+				t.Fatalf("expected %v, got %v", test.code, response.Status)
+			}
+		})
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	server, addr := startTestServer(t)
+	offset := 100
+	err := RegisterFunc(server, "Math.Add", func(ctx context.Context, operands TwoOperands, sum *int) error {
+		*sum = operands.A + operands.B + offset
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("register Math.Add: %v", err)
+	}
+	if err = RegisterFunc(server, "Math.Neg", RpcFunc[int, int](func(ctx context.Context, n int, neg *int) error {
+		*neg = -n
+		return nil
+	})); err != nil {
+		t.Fatalf("register Math.Neg: %v", err)
+	}
+
+	noop := func(ctx context.Context, n int, reply *int) error { return nil }
+	tests := []struct {
+		name string
+		fn   RpcFunc[int, int]
+	}{
+		{"Math.Add", noop},    // registered twice
+		{"Sleeper.Nap", noop}, // a function on a struct service
+		{"Add", noop},         // no service
+		{"Math.Nil", nil},
+	}
+	for _, tt := range tests {
+		if err = RegisterFunc(server, tt.name, tt.fn); err == nil {
+			t.Fatalf("expected registering %s to fail", tt.name)
+		}
+	}
+
+	client := dialTestClient(t, addr)
+	var sum, neg int
+	if err = client.Call(context.Background(), "Math.Add", TwoOperands{A: 1, B: 2}, &sum); err != nil || sum != 103 {
+		t.Fatalf("Math.Add: %d %v", sum, err)
+	}
+	if err = client.Call(context.Background(), "Math.Neg", 7, &neg); err != nil || neg != -7 {
+		t.Fatalf("Math.Neg: %d %v", neg, err)
+	}
+}
+
+type Tenants struct {
+	name string
+}
+
+func (tn *Tenants) Name(prefix string, reply *string) error {
+	*reply = prefix + tn.name
+	return nil
+}
+
+type unexportedService struct{}
+
+func (u *unexportedService) Hello(name string, reply *string) error { return nil }
+
+func TestRegisterName(t *testing.T) {
+	server, addr := startTestServer(t)
+	if err := server.RegisterName("TenantA", &Tenants{name: "a"}); err != nil {
+		t.Fatalf("register TenantA: %v", err)
+	}
+	if err := server.RegisterName("TenantB", &Tenants{name: "b"}); err != nil {
+		t.Fatalf("register TenantB: %v", err)
+	}
+	if err := server.RegisterName("TenantA", &Tenants{name: "c"}); !errors.Is(err, ErrServiceRegistered) {
+		t.Fatalf("expected ErrServiceRegistered, got %v", err)
+	}
+	if err := server.RegisterService(new(unexportedService)); err == nil {
+		t.Fatal("expected an unexported type to be refused")
+	}
+	if err := server.RegisterName("Hello", new(unexportedService)); err != nil {
+		t.Fatalf("register an unexported type under a name: %v", err)
+	}
+	for _, name := range []string{"Tenant.C", "Tenant C", "Tenant\tC"} {
+		if err := server.RegisterName(name, &Tenants{name: "c"}); err == nil {
+			t.Fatalf("expected the name %q to be refused", name)
+		}
+	}
+	if err := server.RegisterName("TenantNil", (*Tenants)(nil)); err == nil {
+		t.Fatal("expected a typed nil pointer to be refused")
+	}
+
+	client := dialTestClient(t, addr)
+	var a, b string
+	if err := client.Call(context.Background(), "TenantA.Name", "tenant-", &a); err != nil || a != "tenant-a" {
+		t.Fatalf("TenantA.Name: %q %v", a, err)
+	}
+	if err := client.Call(context.Background(), "TenantB.Name", "tenant-", &b); err != nil || b != "tenant-b" {
+		t.Fatalf("TenantB.Name: %q %v", b, err)
+	}
+}
+
+func TestUnregister(t *testing.T) {
+	server, addr := startTestServer(t)
+	client := dialTestClient(t, addr)
+
+	// a call already running completes after its service is gone
+	var slept int
+	call := client.Go(context.Background(), "Sleeper.Sleep", 200, &slept, nil)
+	time.Sleep(50 * time.Millisecond)
+	if err := server.Unregister("Sleeper"); err != nil {
+		t.Fatalf("unregister Sleeper: %v", err)
+	}
+	if err := server.Unregister("Sleeper"); !errors.Is(err, ErrServiceNotRegistered) {
+		t.Fatalf("expected ErrServiceNotRegistered, got %v", err)
+	}
+	if err := server.Unregister(HastenProtocol.ReflectionServiceName); !errors.Is(err, ErrBuiltinService) {
+		t.Fatalf("expected ErrBuiltinService, got %v", err)
+	}
+	var list HastenProtocol.ListServicesReply
+	if err := client.Call(context.Background(), HastenProtocol.ListServicesMethod, "", &list); err != nil {
+		t.Fatalf("the reflection service is gone: %v", err)
+	}
+
+	if <-call.Done; call.Error != nil || slept != 200 {
+		t.Fatalf("in-flight Sleeper.Sleep: %d %v", slept, call.Error)
+	}
+	err := client.Call(context.Background(), "Sleeper.Sleep", 0, &slept)
+	if HastenProtocol.CodeOf(err) != HastenProtocol.CodeNotFound {
+		t.Fatalf("expected CodeNotFound after unregister, got %v", err)
+	}
+}
+
+func TestReflection(t *testing.T) {
+	server, addr := startTestServer(t)
+	_ = server.RegisterService(new(Divider))
+	_ = RegisterFunc(server, "Math.Neg", func(ctx context.Context, n int, neg *int) error { return nil })
+	client := dialTestClient(t, addr)
+
+	var list HastenProtocol.ListServicesReply
+	if err := client.Call(context.Background(), HastenProtocol.ListServicesMethod, "", &list); err != nil {
+		t.Fatalf("ListServices: %v", err)
+	}
+	var names []string
+	for _, s := range list.Services {
+		names = append(names, s.Name)
+	}
+	if strings.Join(names, ",") != "Divider,Hasten.Reflection,Math,Sleeper" {
+		t.Fatalf("unexpected services: %v", names)
+	}
+
+	var divider HastenProtocol.ServiceDescriptor
+	if err := client.Call(context.Background(), HastenProtocol.DescribeServiceMethod, "Divider", &divider); err != nil {
+		t.Fatalf("DescribeService: %v", err)
+	}
+	if len(divider.Methods) != 1 {
+		t.Fatalf("unexpected methods: %+v", divider.Methods)
+	}
+	div := divider.Methods[0]
+	if div.StructMethod != "Divider.Div" || div.Args.Kind != "struct" || len(div.Args.Fields) != 2 || div.Reply.Kind != "int" {
+		t.Fatalf("unexpected Divider.Div: %+v %+v %+v", div, div.Args, div.Reply)
+	}
+
+	err := client.Call(context.Background(), HastenProtocol.DescribeServiceMethod, "Nobody", &divider)
+	if HastenProtocol.CodeOf(err) != HastenProtocol.CodeNotFound {
+		t.Fatalf("expected CodeNotFound, got %v", err)
+	}
+}
+
+func TestAcceptWithRegistry(t *testing.T) {
+	registryListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	registryServer := HastenRegistry.StartRegistryServer()
+	t.Cleanup(func() {
+		_ = registryListener.Close()
+		_ = registryServer.Close()
+	})
+	go registryServer.Serve(registryListener)
+	registryAddr := registryListener.Addr().String()
+
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewRpcServer(WithInstance(HastenRegistry.Instance{ID: "sleeper-1", Version: "v2"}))
+	_ = server.RegisterService(new(Sleeper))
+	go server.AcceptWithRegistry(listen, registryAddr, "Sleeper")
+	t.Cleanup(func() { _ = server.Close() })
+
+	// the registry hands out the listener address, not the one of the registration connection
+	var instances []HastenRegistry.Instance
+	for i := 0; i < 50 && len(instances) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		instances, _ = HastenRegistry.NewRegistryClient(registryAddr).Discover(context.Background(), "Sleeper", nil)
+	}
+	want := HastenRegistry.EndpointOf(listen.Addr())
+	if len(instances) != 1 || len(instances[0].Endpoints) != 1 || instances[0].Endpoints[0] != want {
+		t.Fatalf("expected %v, got %+v", want, instances)
+	}
+	if instances[0].ID != "sleeper-1" || instances[0].Version != "v2" {
+		t.Fatalf("expected the instance of WithInstance, got %+v", instances[0])
+	}
+
+	option := HastenProtocol.DefaultOption
+	client, err := HastenClient.NewClientWithRegistryCenter(registryAddr, "Sleeper", &option, HastenClient.Round)
+	if err != nil {
+		t.Fatalf("NewClientWithRegistryCenter: %v", err)
+	}
+	defer client.Close()
+
+	var slept int
+	if err = client.Call(context.Background(), "Sleeper.Sleep", 1, &slept); err != nil || slept != 1 {
+		t.Fatalf("Sleeper.Sleep: %d %v", slept, err)
+	}
+}
+
+// startTestRegistry serves a registry on addr until stop is called
+func startTestRegistry(t *testing.T, addr string) (registryAddr string, stop func()) {
+	listen, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registryServer := HastenRegistry.StartRegistryServer()
+	go registryServer.Serve(listen)
+
+	stop = func() {
+		_ = listen.Close()
+		_ = registryServer.Close()
+	}
+	t.Cleanup(stop)
+	return listen.Addr().String(), stop
+}
+
+func TestRegistrationOutlivesConnection(t *testing.T) {
+	registryAddr, stopRegistry := startTestRegistry(t, "127.0.0.1:0")
+
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewRpcServer(
+		WithInstance(HastenRegistry.Instance{ID: "sleeper-2"}), WithRegistryTTL(HastenRegistry.MinTTL))
+	_ = server.RegisterService(new(Sleeper))
+	go server.AcceptWithRegistry(listen, registryAddr, "Sleeper")
+	t.Cleanup(func() { _ = server.Close() })
+
+	registered := func() bool {
+		instances, _ := HastenRegistry.NewRegistryClient(registryAddr).Discover(context.Background(), "Sleeper", nil)
+		return len(instances) == 1 && instances[0].ID == "sleeper-2"
+	}
+	waitUntil := func(what string, cond func() bool) {
+		for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+		}
+	}
+	var reg *registration
+	waitUntil("the registration", func() bool {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		reg = server.registration
+		return reg != nil
+	})
+
+	// the heartbeats go through Renew once the registration connection is gone
+	_ = reg.registration().Close()
+	time.Sleep(3 * HastenRegistry.MinTTL)
+	if !registered() {
+		t.Fatal("the instance expired after its registration connection broke")
+	}
+
+	// a new registry knows no lease, the instance is registered again
+	stopRegistry()
+	startTestRegistry(t, registryAddr)
+	waitUntil("the instance to be registered again", registered)
+}
+
+func TestShutdownDeregistersAfterBrokenConnection(t *testing.T) {
+	registryAddr, _ := startTestRegistry(t, "127.0.0.1:0")
+	registryClient := HastenRegistry.NewRegistryClient(registryAddr)
+
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewRpcServer(WithInstance(HastenRegistry.Instance{ID: "sleeper-3"}))
+	_ = server.RegisterService(new(Sleeper))
+	go server.AcceptWithRegistry(listen, registryAddr, "Sleeper")
+
+	var reg *registration
+	for deadline := time.Now().Add(5 * time.Second); reg == nil; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the registration")
+		}
+		server.mu.Lock()
+		reg = server.registration
+		server.mu.Unlock()
+	}
+
+	// the registration connection is gone, the lease is still there
+	_ = reg.registration().Close()
+	if _, err = registryClient.Discover(context.Background(), "Sleeper", nil); err != nil {
+		t.Fatalf("discover before shutdown: %v", err)
+	}
+
+	if err = server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if _, err = registryClient.Discover(context.Background(), "Sleeper", nil); !errors.Is(err, HastenRegistry.ErrServiceNotFound) {
+		t.Fatalf("expected ErrServiceNotFound after shutdown, got %v", err)
+	}
+}
+
+// flakyListener fails its first Accept calls with err
+type flakyListener struct {
+	net.Listener
+	failures atomic.Int32
+	err      error
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failures.Add(-1) >= 0 {
+		return nil, l.err
+	}
+	return l.Listener.Accept()
+}
+
+func TestAcceptRetriesTemporaryErrors(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flaky := &flakyListener{Listener: listen, err: &net.OpError{Op: "accept", Net: "tcp", Err: syscall.EMFILE}}
+	flaky.failures.Store(3)
+
+	server := NewRpcServer()
+	_ = server.RegisterService(new(Sleeper))
+	accepted := make(chan error, 1)
+	go func() { accepted <- server.Accept(flaky) }()
+	t.Cleanup(func() { _ = server.Close() })
+
+	client := dialTestClient(t, listen.Addr().String())
+	var reply int
+	if err = client.Call(context.Background(), "Sleeper.Sleep", 1, &reply); err != nil || reply != 1 {
+		t.Fatalf("call after temporary accept errors: %d %v", reply, err)
+	}
+
+	// a permanent error still ends Accept
+	permanent := &flakyListener{Listener: listen, err: errors.New("listener is broken")}
+	permanent.failures.Store(1)
+	if err = NewRpcServer().Accept(permanent); err != permanent.err {
+		t.Fatalf("expected the permanent error, got %v", err)
+	}
+
+	_ = server.Close()
+	if err = <-accepted; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("expected ErrServerClosed, got %v", err)
+	}
+}
@@ -1,71 +1,172 @@
 package HastenServer
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"go/ast"
 	"log"
 	"reflect"
 )
 
-type RpcFunc func(in interface{}, out interface{}) error
+// RpcFunc is the shape of a function RegisterFunc accepts
+type RpcFunc[A any, R any] func(ctx context.Context, args A, reply *R) error
 
 func isExportedOrBuiltinType(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
 	return ast.IsExported(t.Name()) || t.PkgPath() == ""
 }
 
 /*----------------*/
 
+var (
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+// methodType is a registered method, either (args, *reply) error or (ctx, args, *reply) error
+type methodType struct {
+	method    reflect.Method
+	argType   reflect.Type
+	replyType reflect.Type
+	hasCtx    bool // the first parameter is a context.Context
+}
+
+// service is either a struct whose methods are registered or a set of functions, which have no serviceValue
 type service struct {
-	serviceName  string                     //A.k.A struct name
-	serviceType  reflect.Type               //A.k.A struct type
-	serviceValue reflect.Value              //A.k.A struct value
-	methodMap    map[string]*reflect.Method //A.k.A method map
+	serviceName  string                 //A.k.A struct name
+	serviceType  reflect.Type           //A.k.A struct type
+	serviceValue reflect.Value          //A.k.A struct value
+	methodMap    map[string]*methodType //A.k.A method map
 }
 
-func newService[T any](serviceValue T) *service {
+// newService registers the methods of serviceValue under serviceName, an empty serviceName is derived from the type
+func newService[T any](serviceValue T, serviceName string) (*service, error) {
 	sPtr := new(service)
 	sPtr.serviceValue = reflect.ValueOf(serviceValue)
+	if !sPtr.serviceValue.IsValid() ||
+		(sPtr.serviceValue.Kind() == reflect.Ptr && sPtr.serviceValue.IsNil()) {
+		return nil, errors.New("rpc Server: cannot register a nil service")
+	}
 	//
-	sPtr.serviceName = reflect.Indirect(sPtr.serviceValue).Type().Name()
+	sPtr.serviceName = serviceName
 	sPtr.serviceType = reflect.TypeOf(serviceValue)
-	if !ast.IsExported(sPtr.serviceName) {
-		log.Fatalf("rpc Server: %sPtr is not A valid service name", sPtr.serviceName)
+	if serviceName == "" {
+		sPtr.serviceName = reflect.Indirect(sPtr.serviceValue).Type().Name()
+		if !ast.IsExported(sPtr.serviceName) {
+			return nil, fmt.Errorf("rpc Server: %q is not a valid service name", sPtr.serviceName)
+		}
 	}
 	sPtr.registerMethods()
-	return sPtr
+	return sPtr, nil
 }
 
+/*
+registerMethods
+keeps the exported methods shaped like one of
+
+	func (s *T) Method(args A, reply *R) error
+	func (s *T) Method(ctx context.Context, args A, reply *R) error
+
+every other exported method is skipped with a log line telling why.
+*/
 func (s *service) registerMethods() {
-	s.methodMap = make(map[string]*reflect.Method)
+	s.methodMap = make(map[string]*methodType)
 
 	for i := 0; i < s.serviceType.NumMethod(); i++ {
 		method := s.serviceType.Method(i)
-		mType := method.Type
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
-			continue
-		}
-		if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
-			continue
-		}
-
-		argType, replyType := mType.In(1), mType.In(2)
-		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
+		mType, err := newMethodType(method.Type, 1)
+		if err != nil {
+			log.Printf("rpc Server: skip %s.%s: %v\n", s.serviceName, method.Name, err)
 			continue
 		}
+		mType.method = method
 
-		s.methodMap[method.Name] = &method
+		s.methodMap[method.Name] = mType
 		log.Printf("rpc Server: register %s.%s\n", s.serviceName, method.Name)
 	}
 }
 
-func (s *service) call(m *reflect.Method, argv reflect.Value, replyv reflect.Value) error {
+// newMethodType checks the signature fType whose parameters start at index first, the receiver comes before that
+func newMethodType(fType reflect.Type, first int) (*methodType, error) {
+	if fType.NumOut() != 1 || fType.Out(0) != typeOfError {
+		return nil, errors.New("must return exactly one error")
+	}
+
+	numIn := fType.NumIn() - first
+	hasCtx := numIn == 3 && fType.In(first) == typeOfContext
+	if numIn != 2 && !hasCtx {
+		return nil, errors.New("must take (args, *reply) or (context.Context, args, *reply)")
+	}
 
-	returnError := m.Func.Call([]reflect.Value{s.serviceValue, argv, replyv})
+	argType, replyType := fType.In(fType.NumIn()-2), fType.In(fType.NumIn()-1)
+	if replyType.Kind() != reflect.Ptr {
+		return nil, errors.New("reply type " + replyType.String() + " must be a pointer")
+	}
+	if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
+		return nil, errors.New("args and reply types must be exported")
+	}
+
+	return &methodType{
+		argType:   argType,
+		replyType: replyType,
+		hasCtx:    hasCtx,
+	}, nil
+}
+
+// call passes ctx on to the methods which take one, functions are called without a receiver
+func (s *service) call(ctx context.Context, m *methodType, argv reflect.Value, replyv reflect.Value) error {
+
+	in := make([]reflect.Value, 0, 4)
+	if s.serviceValue.IsValid() {
+		in = append(in, s.serviceValue)
+	}
+	if m.hasCtx {
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	in = append(in, argv, replyv)
+
+	returnError := m.method.Func.Call(in)
 	if err := returnError[0].Interface(); err != nil {
 		return err.(error)
 	}
 	return nil
 }
 
+/*
+newFuncMethod
+checks fn the same way as a method, the function itself takes the place of the
+reflected method.
+*/
+func newFuncMethod(methodName string, fn any) (*methodType, error) {
+	fValue := reflect.ValueOf(fn)
+	if fValue.Kind() != reflect.Func || fValue.IsNil() {
+		return nil, fmt.Errorf("%T is not a function", fn)
+	}
+
+	mType, err := newMethodType(fValue.Type(), 0)
+	if err != nil {
+		return nil, err
+	}
+	mType.method = reflect.Method{Name: methodName, Type: fValue.Type(), Func: fValue}
+	return mType, nil
+}
+
+// withFunc returns a copy of the function service s with m added, s itself may be in use by requests
+func (s *service) withFunc(methodName string, m *methodType) *service {
+	funcs := &service{
+		serviceName: s.serviceName,
+		methodMap:   make(map[string]*methodType, len(s.methodMap)+1),
+	}
+	for name, method := range s.methodMap {
+		funcs.methodMap[name] = method
+	}
+	funcs.methodMap[methodName] = m
+	return funcs
+}
+
 /*--------------------------*/
 
 func newArgv(argType reflect.Type) reflect.Value {
@@ -1,7 +1,96 @@
 package HastenServer
 
-import "testing"
+import (
+	"context"
+	"reflect"
+	"testing"
+)
 
 func TestRpcFunc(t *testing.T) {
-	newService(Student{})
+	if _, err := newService(Student{}, ""); err != nil {
+		t.Fatalf("newService: %v", err)
+	}
+}
+
+type Greeter struct{}
+
+func (g *Greeter) Plain(name string, reply *string) error {
+	*reply = "hello " + name
+	return nil
+}
+
+func (g *Greeter) WithCtx(ctx context.Context, name string, reply *string) error {
+	info, _ := RequestInfoFromContext(ctx)
+	*reply = "hello " + name + " from " + info.StructMethod
+	return nil
+}
+
+func (g *Greeter) NoReplyPointer(name string, reply string) error { return nil }
+
+func (g *Greeter) CtxNotFirst(name string, ctx context.Context, reply *string) error { return nil }
+
+func (g *Greeter) NoError(name string, reply *string) {}
+
+func TestRegisterMethods(t *testing.T) {
+	s, err := newService(new(Greeter), "")
+	if err != nil {
+		t.Fatalf("newService: %v", err)
+	}
+
+	tests := []struct {
+		method     string
+		registered bool
+		hasCtx     bool
+	}{
+		{"Plain", true, false},
+		{"WithCtx", true, true},
+		{"NoReplyPointer", false, false},
+		{"CtxNotFirst", false, false},
+		{"NoError", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			m, ok := s.methodMap[tt.method]
+			if ok != tt.registered {
+				t.Fatalf("registered = %v, want %v", ok, tt.registered)
+			}
+			if ok && m.hasCtx != tt.hasCtx {
+				t.Fatalf("hasCtx = %v, want %v", m.hasCtx, tt.hasCtx)
+			}
+		})
+	}
+
+	ctx := context.WithValue(context.Background(), requestInfoKey{}, RequestInfo{StructMethod: "Greeter.WithCtx"})
+	var reply string
+	err = s.call(ctx, s.methodMap["WithCtx"], reflect.ValueOf("bob"), reflect.ValueOf(&reply))
+	if err != nil || reply != "hello bob from Greeter.WithCtx" {
+		t.Fatalf("call WithCtx: %q %v", reply, err)
+	}
+}
+
+func TestNewFuncMethod(t *testing.T) {
+	tests := []struct {
+		name   string
+		fn     any
+		valid  bool
+		hasCtx bool
+	}{
+		{"typed", RpcFunc[string, string](func(ctx context.Context, name string, reply *string) error { return nil }), true, true},
+		{"without ctx", func(name string, reply *string) error { return nil }, true, false},
+		{"not a function", "Math.Add", false, false},
+		{"nil function", (func(string, *string) error)(nil), false, false},
+		{"no reply pointer", func(name string, reply string) error { return nil }, false, false},
+		{"no error", func(name string, reply *string) {}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newFuncMethod("Fn", tt.fn)
+			if (err == nil) != tt.valid {
+				t.Fatalf("err = %v, want valid %v", err, tt.valid)
+			}
+			if err == nil && m.hasCtx != tt.hasCtx {
+				t.Fatalf("hasCtx = %v, want %v", m.hasCtx, tt.hasCtx)
+			}
+		})
+	}
 }
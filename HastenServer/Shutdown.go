@@ -0,0 +1,180 @@
+package HastenServer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"oh_my_rpc_v2/HastenProtocol"
+	"oh_my_rpc_v2/HastenRegistry"
+	"sync"
+)
+
+/*
+Shutdown
+stops the server gracefully:
+ 1. stop accepting, Accept returns ErrServerClosed
+ 2. send a go-away frame to every connected client, later requests are refused
+ 3. deregister from the registry center
+ 4. wait for the requests in flight until ctx is done
+ 5. cancel the context of the requests still running and close every connection
+
+the error of ctx is returned if the requests did not finish in time, joined by
+the error of the deregistration, the server stops either way.
+*/
+func (server *RpcServer) Shutdown(ctx context.Context) error {
+	conns, deregisterErr := server.beginShutdown(ctx)
+
+	var err error
+	for _, sc := range conns {
+		if err = waitRequests(ctx, sc.wg); err != nil {
+			break
+		}
+	}
+
+	// the handlers still running see their context canceled
+	server.cancel()
+	server.closeConns()
+	return errors.Join(err, deregisterErr)
+}
+
+// Close stops the server without waiting for the requests in flight, only the deregistration can fail
+func (server *RpcServer) Close() error {
+	_, err := server.beginShutdown(context.Background())
+	server.cancel()
+	server.closeConns()
+	return err
+}
+
+// beginShutdown runs the steps 1 to 3 of Shutdown and returns the connections to drain
+func (server *RpcServer) beginShutdown(ctx context.Context) ([]*serverConn, error) {
+	server.mu.Lock()
+	server.shuttingDown.Store(true)
+
+	for listener := range server.listeners {
+		if err := listener.Close(); err != nil {
+			log.Println("rpc server: close listener error:", err)
+		}
+	}
+
+	conns := make([]*serverConn, 0, len(server.conns))
+	codecs := make([]HastenProtocol.RpcCodec, 0, len(server.conns))
+	for sc := range server.conns {
+		conns = append(conns, sc)
+		if sc.codec != nil {
+			codecs = append(codecs, sc.codec)
+		}
+	}
+
+	reg := server.registration
+	server.registration = nil
+	server.mu.Unlock()
+
+	for _, codec := range codecs {
+		server.sendRpcResponse(codec, &HastenProtocol.Header{Flags: HastenProtocol.FlagGoAway}, nil)
+	}
+
+	var err error
+	if reg != nil {
+		err = deregister(ctx, reg)
+	}
+	return conns, err
+}
+
+func (server *RpcServer) closeConns() {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	for sc := range server.conns {
+		if sc.codec != nil {
+			_ = sc.codec.Close()
+		} else {
+			_ = sc.conn.Close()
+		}
+	}
+}
+
+/*
+deregister
+stops the heartbeats first, so no lease is taken out again behind the
+Deregister. A registration connection which broke, or was closed by the
+heartbeats falling back to Renew, is bridged with Revoke on a new one. A lease
+the registry no longer knows has taken the instance with it.
+*/
+func deregister(ctx context.Context, reg *registration) error {
+	reg.cancel()
+	<-reg.stopped
+
+	current := reg.registration()
+	err := current.Deregister(ctx)
+	if err != nil && !errors.Is(err, HastenRegistry.ErrServiceNotFound) {
+		err = reg.client.Revoke(ctx, current.LeaseID)
+	}
+	if err != nil && !errors.Is(err, HastenRegistry.ErrServiceNotFound) {
+		log.Println("rpc server: deregister error:", err)
+		return fmt.Errorf("rpc server: deregister %s: %w", current.ServiceName, err)
+	}
+	return nil
+}
+
+func waitRequests(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// beginRequest counts a request on wg unless the server is shutting down
+func (server *RpcServer) beginRequest(wg *sync.WaitGroup) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if server.shuttingDown.Load() {
+		return false
+	}
+	wg.Add(1)
+	return true
+}
+
+func (server *RpcServer) trackListener(listener net.Listener) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if server.shuttingDown.Load() {
+		return false
+	}
+	server.listeners[listener] = struct{}{}
+	return true
+}
+
+func (server *RpcServer) untrackListener(listener net.Listener) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	delete(server.listeners, listener)
+}
+
+func (server *RpcServer) trackConn(sc *serverConn) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if server.shuttingDown.Load() {
+		return false
+	}
+	server.conns[sc] = struct{}{}
+	return true
+}
+
+func (server *RpcServer) untrackConn(sc *serverConn) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	delete(server.conns, sc)
+}
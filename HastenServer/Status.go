@@ -0,0 +1,27 @@
+package HastenServer
+
+import (
+	"errors"
+	"oh_my_rpc_v2/HastenProtocol"
+)
+
+/*
+Errorf
+builds the error a handler returns to answer with a specific code, e.g.
+
+	return HastenServer.Errorf(HastenProtocol.CodeInvalidArgument, "divisor %d", args.B)
+
+errors without a code reach the caller as HastenProtocol.CodeUnknown.
+*/
+func Errorf(code HastenProtocol.ErrorCode, format string, a ...any) error {
+	return HastenProtocol.Errorf(code, format, a...)
+}
+
+// statusOf turns the error of a request into the Status of its response
+func statusOf(err error) *HastenProtocol.Status {
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) {
+		return HastenProtocol.NewStatus(HastenProtocol.CodeInternal, panicErr.Error())
+	}
+	return HastenProtocol.StatusFromError(err)
+}
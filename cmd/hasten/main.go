@@ -0,0 +1,199 @@
+/*
+hasten
+calls a running RpcServer from the shell, through the json codec and the
+reflection service of the server:
+
+	hasten -addr 127.0.0.1:12312 list
+	hasten -addr 127.0.0.1:12312 describe ComputeS1
+	hasten -addr 127.0.0.1:12312 call ComputeS1.Add '{"A": 1, "B": 2}'
+	echo '{"A": 1, "B": 2}' | hasten -registry 127.0.0.1:9999 -service ComputeS1 call ComputeS1.Add -
+
+exit codes: 0 success, 1 the server answered with an error, 2 bad usage, 3 the
+server could not be reached.
+*/
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"oh_my_rpc_v2/HastenClient"
+	"oh_my_rpc_v2/HastenProtocol"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	exitOK          = 0
+	exitRpcError    = 1
+	exitUsage       = 2
+	exitUnreachable = 3
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// metadataFlag collects -H key=value
+type metadataFlag HastenProtocol.Metadata
+
+func (m metadataFlag) String() string {
+	return fmt.Sprint(HastenProtocol.Metadata(m))
+}
+
+func (m metadataFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("want key=value, got %q", value)
+	}
+	m[key] = val
+	return nil
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("hasten", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	addr := flags.String("addr", "", "address of the server, e.g. 127.0.0.1:12312")
+	registry := flags.String("registry", "", "address of the registry, used with -service instead of -addr")
+	serviceName := flags.String("service", "", "service to discover in the registry")
+	timeout := flags.Duration("timeout", 10*time.Second, "deadline of the call")
+	verbose := flags.Bool("v", false, "print the logs of the rpc library")
+	metadata := metadataFlag{}
+	flags.Var(metadata, "H", "request metadata key=value, may be repeated")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: hasten [flags] list [prefix] | describe <Service> | call <Service.Method> [json|-]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() == 0 || (*addr == "") == (*registry == "") || (*registry != "" && *serviceName == "") {
+		flags.Usage()
+		return exitUsage
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	command, operands := flags.Arg(0), flags.Args()[1:]
+	var method string
+	var body json.RawMessage
+	switch {
+	case command == "list" && len(operands) <= 1:
+		method = HastenProtocol.ListServicesMethod
+		body, _ = json.Marshal(strings.Join(operands, ""))
+	case command == "describe" && len(operands) == 1:
+		method = HastenProtocol.DescribeServiceMethod
+		body, _ = json.Marshal(operands[0])
+	case command == "call" && (len(operands) == 1 || len(operands) == 2):
+		method = operands[0]
+		var err error
+		if body, err = readArgs(operands[1:], stdin); err != nil {
+			fmt.Fprintln(stderr, "hasten:", err)
+			return exitUsage
+		}
+	default:
+		flags.Usage()
+		return exitUsage
+	}
+
+	client, err := dial(*addr, *registry, *serviceName)
+	if err != nil {
+		fmt.Fprintln(stderr, "hasten:", err)
+		return exitUnreachable
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	var reply json.RawMessage
+	err = client.Call(ctx, method, body, &reply, HastenClient.WithMetadata(HastenProtocol.Metadata(metadata)))
+	if err != nil {
+		fmt.Fprintln(stderr, "hasten:", err)
+		if errors.Is(err, HastenClient.ErrShutdown) {
+			return exitUnreachable
+		}
+		return exitRpcError
+	}
+
+	if command == "list" {
+		return printServices(reply, stdout, stderr)
+	}
+	return printJSON(reply, stdout, stderr)
+}
+
+// readArgs returns the json arguments of call, "-" reads them from stdin and none means null
+func readArgs(operands []string, stdin io.Reader) (json.RawMessage, error) {
+	data := []byte("null")
+	if len(operands) == 1 {
+		data = []byte(operands[0])
+	}
+	if len(operands) == 1 && operands[0] == "-" {
+		var err error
+		if data, err = io.ReadAll(stdin); err != nil {
+			return nil, err
+		}
+	}
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("arguments are not valid json: %s", data)
+	}
+	return data, nil
+}
+
+func dial(addr string, registry string, serviceName string) (*HastenClient.Client, error) {
+	option := HastenProtocol.DefaultOption
+	option.CodecType = HastenProtocol.JsonType
+
+	if registry != "" {
+		return HastenClient.NewClientWithRegistryCenter(registry, serviceName, &option, HastenClient.Round)
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, HastenClient.HandshakeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return HastenClient.NewClient(conn, &option)
+}
+
+func printJSON(reply json.RawMessage, stdout io.Writer, stderr io.Writer) int {
+	out, err := json.MarshalIndent(reply, "", "  ")
+	if err != nil {
+		fmt.Fprintln(stderr, "hasten:", err)
+		return exitRpcError
+	}
+	fmt.Fprintln(stdout, string(out))
+	return exitOK
+}
+
+// printServices prints one method per line: the name to call, its args type and its reply type
+func printServices(reply json.RawMessage, stdout io.Writer, stderr io.Writer) int {
+	var list HastenProtocol.ListServicesReply
+	if err := json.Unmarshal(reply, &list); err != nil {
+		fmt.Fprintln(stderr, "hasten:", err)
+		return exitRpcError
+	}
+	for _, s := range list.Services {
+		for _, m := range s.Methods {
+			fmt.Fprintf(stdout, "%s\t%s\t%s\n", m.StructMethod, typeName(m.Args), typeName(m.Reply))
+		}
+	}
+	return exitOK
+}
+
+func typeName(schema *HastenProtocol.TypeSchema) string {
+	if schema == nil {
+		return ""
+	}
+	if schema.Name != "" {
+		return schema.Name
+	}
+	return schema.Kind
+}
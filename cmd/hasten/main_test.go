@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"oh_my_rpc_v2/HastenServer"
+	"strings"
+	"testing"
+)
+
+func startServer(t *testing.T) string {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := HastenServer.NewRpcServer()
+	_ = server.RegisterService(HastenServer.NewComputeS1())
+	go server.Accept(listen)
+	t.Cleanup(func() { _ = server.Close() })
+	return listen.Addr().String()
+}
+
+func TestRun(t *testing.T) {
+	addr := startServer(t)
+
+	tests := []struct {
+		name   string
+		args   []string
+		stdin  string
+		code   int
+		stdout string // a substring of the output
+	}{
+		{"call", []string{"-addr", addr, "call", "ComputeS1.Add", `{"A": 1, "B": 2}`}, "", exitOK, "3"},
+		{"call from stdin", []string{"-addr", addr, "call", "ComputeS1.Add", "-"}, `{"A": 2, "B": 2}`, exitOK, "4"},
+		{"list", []string{"-addr", addr, "list"}, "", exitOK, "ComputeS1.Add\tHastenServer.TwoOperands\tint"},
+		{"describe", []string{"-addr", addr, "describe", "ComputeS1"}, "", exitOK, `"StructMethod": "ComputeS1.Add"`},
+		{"unknown method", []string{"-addr", addr, "call", "ComputeS1.Mul", "{}"}, "", exitRpcError, ""},
+		{"invalid json", []string{"-addr", addr, "call", "ComputeS1.Add", "{A"}, "", exitUsage, ""},
+		{"no command", []string{"-addr", addr}, "", exitUsage, ""},
+		{"no address", []string{"list"}, "", exitUsage, ""},
+		{"unreachable", []string{"-addr", "127.0.0.1:1", "list"}, "", exitUnreachable, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+			if code != tt.code {
+				t.Fatalf("exit code %d, want %d, stderr: %s", code, tt.code, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.stdout) {
+				t.Fatalf("expected %q in output: %s", tt.stdout, stdout.String())
+			}
+		})
+	}
+}